@@ -0,0 +1,315 @@
+package titanium
+
+import (
+	"context"
+	"fmt"
+)
+
+// Returns the kernel of the named project.
+type KernelLookup func(project string) (Kernel, error)
+
+// Looks kernels up in a fixed set, keyed by project name.
+func MapKernels(kernels map[string]Kernel) KernelLookup {
+	return func(project string) (Kernel, error) {
+		kernel, ok := kernels[project]
+		if !ok {
+			return Kernel{}, fmt.Errorf("Unknown kernel project %q", project)
+		}
+		return kernel, nil
+	}
+}
+
+// Looks kernels up by fetching their project definitions.
+func (client *HttpClient) KernelLookup(ctx context.Context) KernelLookup {
+	return func(project string) (Kernel, error) {
+		definition, err := client.GetProjectDefinition(ctx, project)
+		if err != nil {
+			return Kernel{}, err
+		}
+		if definition.Kernel == nil {
+			return Kernel{}, fmt.Errorf("Project %q is not a kernel", project)
+		}
+		return *definition.Kernel, nil
+	}
+}
+
+// Builds the interfaces and entities of a system project. Aliases are
+// allocated automatically and every connection is checked against the
+// interfaces of the kernels involved. Connections may be made in any order,
+// aliases are resolved by Build. The first error encountered is kept and
+// returned by Build.
+type SystemBuilder struct {
+	lookup KernelLookup
+	err    error
+
+	interfaces []ProjectInterface
+	// Port each interface is connected to, in the order of interfaces
+	ports    []*Port
+	entities []*EntityBuilder
+}
+
+// An entity of the system, running the kernel of another project.
+type EntityBuilder struct {
+	system *SystemBuilder
+	name   string
+	kernel string
+	ports  map[string]*Port
+	// Kernel interface order, so Build output is stable
+	order []string
+}
+
+// One end of a connection: either an input of the system or an interface of
+// one of its entities.
+type Port struct {
+	Name      string
+	Type      TypeType
+	Direction DirectionType
+	Optional  bool
+	Kind      ValueKind
+
+	entity *EntityBuilder
+	alias  string
+	// Port the input is connected to, whose alias it takes
+	source *Port
+}
+
+func NewSystemBuilder(lookup KernelLookup) *SystemBuilder {
+	return &SystemBuilder{
+		lookup: lookup,
+	}
+}
+
+func (system *SystemBuilder) fail(format string, args ...interface{}) {
+	if system.err == nil {
+		system.err = fmt.Errorf(format, args...)
+	}
+}
+
+func (system *SystemBuilder) hasInterface(name string) bool {
+	for _, pinterface := range system.interfaces {
+		if pinterface.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Declares an input of the system, to be connected to entity interfaces.
+// Parameter and secret inputs hold strings, see InputKind.
+func (system *SystemBuilder) Input(name string, nodeType TypeType) *Port {
+	return system.InputKind(name, nodeType, StringKind)
+}
+
+// Declares an input of the system holding values of kind, to be connected to
+// entity interfaces of the same kind.
+func (system *SystemBuilder) InputKind(name string, nodeType TypeType, kind ValueKind) *Port {
+	if system.hasInterface(name) {
+		system.fail("Duplicate system interface %q", name)
+	}
+
+	port := &Port{
+		Name:      name,
+		Type:      nodeType,
+		Direction: InDirection,
+		Kind:      kind,
+		alias:     name,
+	}
+	system.interfaces = append(system.interfaces, ProjectInterface{
+		Name:      name,
+		Type:      nodeType,
+		Direction: InDirection,
+		Kind:      kind,
+	})
+	system.ports = append(system.ports, port)
+	return port
+}
+
+// Exposes an entity output as an output of the system, under the name of the
+// entity interface.
+func (system *SystemBuilder) Output(port *Port) {
+	if !port.valid() {
+		system.fail("System output from an unknown port")
+		return
+	}
+	system.OutputAs(port.Name, port)
+}
+
+// Exposes an entity output as an output of the system named name.
+func (system *SystemBuilder) OutputAs(name string, port *Port) {
+	if !port.valid() {
+		system.fail("System output %q from an unknown port", name)
+		return
+	}
+	if port.entity == nil {
+		system.fail("System output %q must come from an entity", name)
+		return
+	}
+	if port.Direction != OutDirection && port.Direction != InOutDirection {
+		system.fail("Interface %q of entity %q is not an output", port.Name, port.entity.name)
+		return
+	}
+	if system.hasInterface(name) {
+		system.fail("Duplicate system interface %q", name)
+		return
+	}
+
+	system.interfaces = append(system.interfaces, ProjectInterface{
+		Name:      name,
+		Type:      port.Type,
+		Direction: OutDirection,
+		Optional:  port.Optional,
+		Kind:      port.Kind,
+	})
+	system.ports = append(system.ports, port)
+}
+
+// Adds an entity named name running the kernel of project.
+func (system *SystemBuilder) Use(name, project string) *EntityBuilder {
+	entity := &EntityBuilder{
+		system: system,
+		name:   name,
+		kernel: project,
+		ports:  make(map[string]*Port),
+	}
+
+	for _, other := range system.entities {
+		if other.name == name {
+			system.fail("Duplicate entity %q", name)
+		}
+	}
+	system.entities = append(system.entities, entity)
+
+	kernel, err := system.lookup(project)
+	if err != nil {
+		system.fail("Entity %q: %s", name, err)
+		return entity
+	}
+
+	for _, kinterface := range kernel.Interfaces {
+		entity.ports[kinterface.Name] = &Port{
+			Name:      kinterface.Name,
+			Type:      kinterface.Type,
+			Direction: kinterface.Direction,
+			Optional:  kinterface.Optional,
+			Kind:      kinterface.Kind,
+			entity:    entity,
+			alias:     name + "." + kinterface.Name,
+		}
+		entity.order = append(entity.order, kinterface.Name)
+	}
+
+	return entity
+}
+
+// Returns the named interface of the entity's kernel.
+func (entity *EntityBuilder) Port(name string) *Port {
+	port, ok := entity.ports[name]
+	if !ok {
+		entity.system.fail("Kernel %q of entity %q has no interface %q", entity.kernel, entity.name, name)
+		return &Port{Name: name, entity: entity}
+	}
+	return port
+}
+
+// Connects the named input of the entity to source, which is either a system
+// input or an output of another entity.
+func (entity *EntityBuilder) Connect(name string, source *Port) *EntityBuilder {
+	target := entity.Port(name)
+	if !source.valid() {
+		entity.system.fail("Interface %q of entity %q is connected to an unknown port", name, entity.name)
+		return entity
+	}
+	if target.Direction != InDirection && target.Direction != InOutDirection {
+		entity.system.fail("Interface %q of entity %q is not an input", name, entity.name)
+		return entity
+	}
+	if source.entity == entity {
+		entity.system.fail("Entity %q can not be connected to itself", entity.name)
+		return entity
+	}
+	if source.entity != nil && source.Direction != OutDirection && source.Direction != InOutDirection {
+		entity.system.fail("Interface %q of entity %q is not an output", source.Name, source.entity.name)
+		return entity
+	}
+	if source.Type != target.Type {
+		entity.system.fail("Can not connect %s %q to %s interface %q of entity %q", source.Type, source.Name, target.Type, name, entity.name)
+		return entity
+	}
+	if source.Kind != target.Kind {
+		entity.system.fail("Can not connect %s value %q to %s interface %q of entity %q", source.Kind, source.Name, target.Kind, name, entity.name)
+		return entity
+	}
+
+	target.source = source
+	return entity
+}
+
+// Returns whether the port was returned by Input or Port, rather than nil or
+// built by hand.
+func (port *Port) valid() bool {
+	return port != nil && (port.entity != nil || port.alias != "")
+}
+
+// Returns the alias of the port, that of the port it is connected to if any.
+func (port *Port) resolve() (string, error) {
+	seen := make(map[*Port]bool)
+	for port.source != nil {
+		if seen[port] {
+			return "", fmt.Errorf("Interface %q of entity %q is connected to itself", port.Name, port.entity.name)
+		}
+		seen[port] = true
+		port = port.source
+	}
+	return port.alias, nil
+}
+
+// Returns the validated interfaces and entities of the system.
+func (system *SystemBuilder) Build() ([]ProjectInterface, []ConfigurationEntity, error) {
+	if system.err != nil {
+		return nil, nil, system.err
+	}
+
+	entities := make([]ConfigurationEntity, len(system.entities))
+	for index, entity := range system.entities {
+		entities[index] = ConfigurationEntity{
+			Name:   entity.name,
+			Kernel: entity.kernel,
+		}
+
+		for _, name := range entity.order {
+			port := entity.ports[name]
+			isInput := port.Direction == InDirection
+			if isInput && port.source == nil {
+				if port.Optional {
+					continue
+				}
+				return nil, nil, fmt.Errorf("Input %q of entity %q is not connected", name, entity.name)
+			}
+
+			alias, err := port.resolve()
+			if err != nil {
+				return nil, nil, err
+			}
+			entities[index].Interfaces = append(entities[index].Interfaces, ConfigurationEntityInterface{
+				Name:  name,
+				Alias: alias,
+			})
+		}
+	}
+
+	interfaces := append([]ProjectInterface(nil), system.interfaces...)
+	for index, port := range system.ports {
+		alias, err := port.resolve()
+		if err != nil {
+			return nil, nil, err
+		}
+		interfaces[index].Alias = alias
+	}
+
+	err := ValidateSystem(interfaces, entities)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return interfaces, entities, nil
+}
@@ -0,0 +1,307 @@
+package titanium
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/atomosio/common"
+)
+
+// Number of responses kept by a client cache, the least recently fetched are
+// dropped first.
+var CacheMaxEntries = 1024
+
+// Caches the responses of cluster, instance and project reads for ttl. Once
+// stale, a response is revalidated with an If-None-Match request carrying its
+// ETag, and reused if the server answers 304 Not Modified. Mutating requests
+// to a cluster, instance or project drop its cached responses, and responses
+// to reads of it that were in flight meanwhile are not kept or shared.
+//
+// Identical GET requests are coalesced whether or not a cache is in use.
+func (client *HttpClient) WithCache(ttl time.Duration) *HttpClient {
+	client.cache = &responseCache{
+		ttl:         ttl,
+		generations: &client.generations,
+		entries:     make(map[string]*cacheEntry),
+	}
+	return client
+}
+
+// Drops every cached response.
+func (client *HttpClient) ClearCache() {
+	if client.cache != nil {
+		client.cache.clear()
+	}
+}
+
+// Sends GET requests through the flight group and the cache, and invalidates
+// the cached responses of the resource other requests modify.
+func (client *HttpClient) doCachedAndRead(req *http.Request) ([]byte, error) {
+	resource := client.resource(req.URL.Path)
+	if req.Method != "GET" {
+		if resource == "" {
+			return client.doAndRead(req)
+		}
+
+		// Reads started before the mutation is sent may see the old state, and
+		// so may reads started while it is in flight: bump the generation on
+		// both sides so neither is shared or stored.
+		client.invalidate(resource)
+		defer client.invalidate(resource)
+		return client.doAndRead(req)
+	}
+
+	// Requests made with different tokens may see different responses
+	key := req.Header.Get("Authorization") + " " + req.URL.String()
+	generation := client.generations.get(resource)
+	flightKey := key + " " + strconv.FormatUint(generation, 10)
+	return client.flights.do(req.Context(), flightKey, func(ctx context.Context) ([]byte, error) {
+		req := req.WithContext(ctx)
+		if client.cache == nil || resource == "" {
+			return client.doAndRead(req)
+		}
+		return client.cache.read(key, resource, generation, req, client.doAndReadResponse)
+	})
+}
+
+func (client *HttpClient) invalidate(resource string) {
+	client.generations.bump(resource)
+	if client.cache != nil {
+		client.cache.invalidate(resource)
+	}
+}
+
+func (client *HttpClient) doAndRead(req *http.Request) ([]byte, error) {
+	_, data, err := client.doAndReadResponse(req)
+	return data, err
+}
+
+func (client *HttpClient) doAndReadResponse(req *http.Request) (*http.Response, []byte, error) {
+	resp, err := client.do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	return resp, data, err
+}
+
+// Returns the cluster, instance or project addressed by path, as in
+// 'clusters/12', or an empty string for other endpoints.
+func (client *HttpClient) resource(path string) string {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, client.path), "/")
+	for _, endpoint := range []string{ClustersEndpoint, InstancesEndpoint, ProjectsEndpoint} {
+		if !strings.HasPrefix(path, endpoint) {
+			continue
+		}
+
+		name := strings.TrimPrefix(path, endpoint)
+		if index := strings.Index(name, "/"); index >= 0 {
+			name = name[:index]
+		}
+		if name == "" {
+			return ""
+		}
+		return endpoint + name
+	}
+	return ""
+}
+
+// Counts the mutations sent to each resource.
+type resourceGenerations struct {
+	mutex  sync.Mutex
+	counts map[string]uint64
+}
+
+func (generations *resourceGenerations) get(resource string) uint64 {
+	generations.mutex.Lock()
+	defer generations.mutex.Unlock()
+
+	return generations.counts[resource]
+}
+
+func (generations *resourceGenerations) bump(resource string) {
+	generations.mutex.Lock()
+	defer generations.mutex.Unlock()
+
+	if generations.counts == nil {
+		generations.counts = make(map[string]uint64)
+	}
+	generations.counts[resource]++
+}
+
+type responseCache struct {
+	ttl         time.Duration
+	generations *resourceGenerations
+
+	mutex   sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+type cacheEntry struct {
+	resource   string
+	generation uint64
+	data       []byte
+	etag       string
+	fetched    time.Time
+}
+
+// Returns the response to req, read with the resource at generation.
+func (cache *responseCache) read(key, resource string, generation uint64, req *http.Request, do func(*http.Request) (*http.Response, []byte, error)) ([]byte, error) {
+	cache.mutex.Lock()
+	entry, ok := cache.entries[key]
+	if ok && entry.generation != generation {
+		delete(cache.entries, key)
+		ok = false
+	}
+	cache.mutex.Unlock()
+
+	if ok && time.Since(entry.fetched) < cache.ttl {
+		return entry.data, nil
+	}
+	if ok && entry.etag != "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("If-None-Match", entry.etag)
+	}
+
+	resp, data, err := do(req)
+	if err != nil {
+		return data, err
+	}
+
+	if ok && resp.StatusCode == http.StatusNotModified {
+		cache.store(key, &cacheEntry{
+			resource:   resource,
+			generation: generation,
+			data:       entry.data,
+			etag:       entry.etag,
+			fetched:    time.Now(),
+		})
+		return entry.data, nil
+	}
+
+	// Only successful responses are worth keeping
+	var response Response
+	if statusGood(resp.StatusCode) && json.Unmarshal(data, &response) == nil && response.Code == common.Success {
+		cache.store(key, &cacheEntry{
+			resource:   resource,
+			generation: generation,
+			data:       data,
+			etag:       resp.Header.Get("ETag"),
+			fetched:    time.Now(),
+		})
+	} else if ok {
+		cache.remove(key)
+	}
+	return data, nil
+}
+
+// Keeps entry unless the resource was modified since it was read.
+func (cache *responseCache) store(key string, entry *cacheEntry) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if entry.generation != cache.generations.get(entry.resource) {
+		return
+	}
+	cache.entries[key] = entry
+	for len(cache.entries) > CacheMaxEntries {
+		var oldest string
+		for key, entry := range cache.entries {
+			if oldest == "" || entry.fetched.Before(cache.entries[oldest].fetched) {
+				oldest = key
+			}
+		}
+		delete(cache.entries, oldest)
+	}
+}
+
+func (cache *responseCache) remove(key string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	delete(cache.entries, key)
+}
+
+func (cache *responseCache) invalidate(resource string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	for key, entry := range cache.entries {
+		if entry.resource == resource {
+			delete(cache.entries, key)
+		}
+	}
+}
+
+func (cache *responseCache) clear() {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cache.entries = make(map[string]*cacheEntry)
+}
+
+// Coalesces identical requests made while one is already in flight.
+type flightGroup struct {
+	mutex   sync.Mutex
+	flights map[string]*flight
+}
+
+type flight struct {
+	done    chan struct{}
+	data    []byte
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// Calls fn once for all callers sharing key while it runs. fn is given a
+// context that keeps the values of the first caller's, and is canceled once
+// every caller has given up waiting.
+func (group *flightGroup) do(ctx context.Context, key string, fn func(context.Context) ([]byte, error)) ([]byte, error) {
+	group.mutex.Lock()
+	if group.flights == nil {
+		group.flights = make(map[string]*flight)
+	}
+	f, ok := group.flights[key]
+	if !ok {
+		flightCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		f = &flight{
+			done:   make(chan struct{}),
+			cancel: cancel,
+		}
+		group.flights[key] = f
+
+		go func() {
+			f.data, f.err = fn(flightCtx)
+			cancel()
+
+			group.mutex.Lock()
+			delete(group.flights, key)
+			group.mutex.Unlock()
+			close(f.done)
+		}()
+	}
+	f.waiters++
+	group.mutex.Unlock()
+
+	select {
+	case <-f.done:
+		return f.data, f.err
+	case <-ctx.Done():
+		group.mutex.Lock()
+		f.waiters--
+		if f.waiters == 0 {
+			f.cancel()
+		}
+		group.mutex.Unlock()
+		return nil, ctx.Err()
+	}
+}
@@ -1,8 +1,15 @@
 package titanium
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	neturl "net/url"
+	"os"
 	"strconv"
 	"time"
 
@@ -20,9 +27,15 @@ const (
 
 const (
 	InvalidClusterType = iota
-	BatchClusterType
+	BatchClusterType   // Runs the project once to completion
+	ServiceClusterType // Keeps a number of replicas of the project running
+	ArrayClusterType   // Runs a number of indexed instances of the project
 )
 
+// Environment variable holding the index of an instance within an array
+// cluster.
+const ArrayIndexEnv = "TITANIUM_ARRAY_INDEX"
+
 var (
 	ClusterStatusStrings = []string{
 		"Invalid",
@@ -33,22 +46,38 @@ var (
 	TypeStrings = []string{
 		"Invalid",
 		"Batch",
+		"Service",
+		"Array",
 	}
 	TypeStringsTo = map[string]int{
 		TypeStrings[InvalidClusterType]: InvalidClusterType,
 		TypeStrings[BatchClusterType]:   BatchClusterType,
+		TypeStrings[ServiceClusterType]: ServiceClusterType,
+		TypeStrings[ArrayClusterType]:   ArrayClusterType,
 	}
 
 	ErrClusterWaitForFinishTimeout = errors.New("Cluster did not finish within timeout period")
+	ErrInvalidClusterType          = errors.New("Invalid cluster type")
+	ErrInvalidReplicas             = errors.New("Service clusters require atleast one replica")
+	ErrInvalidArrayCount           = errors.New("Array clusters require atleast one instance")
+	ErrServiceOnlyOption           = errors.New("Replicas and RestartOnExit only apply to service clusters")
+	ErrArrayOnlyOption             = errors.New("Count only applies to array clusters")
 )
 
+// Number of times a cluster creation is attempted before giving up. Only
+// transport errors and 5xx responses are retried.
+var CreateClusterAttempts = 3
+
 type Cluster struct {
 	Response
 
-	IdString        string   `json:"cluster_id"`
-	Status          string   `json:"status"`
-	ClustersString  []string `json:"clusters"`
-	InstancesString []string `json:"instances"`
+	IdString        string          `json:"cluster_id"`
+	Name            string          `json:"name"`
+	Project         string          `json:"project"`
+	Status          string          `json:"status"`
+	ClustersString  []string        `json:"clusters"`
+	InstancesString []string        `json:"instances"`
+	Retry           *OutRetryPolicy `json:"retry,omitempty"`
 
 	Id        int64
 	Clusters  []int64
@@ -60,6 +89,69 @@ type CreateClusterRequest struct {
 	Name       string            `json:"name"`
 	Project    string            `json:"project"`
 	Interfaces map[string]string `json:"interfaces"`
+
+	// Requests carrying the same key are only ever acted upon once, retries
+	// are answered with the id of the originally created cluster.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+
+	// Service clusters
+	Replicas      int  `json:"replicas,omitempty"`
+	RestartOnExit bool `json:"restart_on_exit,omitempty"`
+
+	// Array clusters
+	Count int `json:"count,omitempty"`
+
+	// Clusters that must be stopped before this one leaves the waiting state
+	DependsOn []string `json:"depends_on,omitempty"`
+
+	Retry *OutRetryPolicy `json:"retry,omitempty"`
+
+	// Overrides the resources declared by the kernel
+	Resources *Resources `json:"resources,omitempty"`
+
+	// Added to the environment declared by the kernel
+	Env map[string]string `json:"env,omitempty"`
+}
+
+// Describes a cluster to be created with CreateCluster.
+type ClusterSpec struct {
+	// One of the *ClusterType constants
+	Type int
+	Name string
+	// Project to run, optionally pinned to a version or tag as in
+	// 'name@version'
+	Project    string
+	Interfaces map[string]string
+
+	// If empty, a random key is generated.
+	IdempotencyKey string
+
+	// Number of instances a service cluster keeps running.
+	Replicas int
+	// Whether service instances that exit are started again.
+	RestartOnExit bool
+
+	// Number of instances an array cluster launches. Each instance finds its
+	// index, starting at 0, in the ArrayIndexEnv environment variable.
+	Count int
+
+	// Ids of clusters that must stop before this cluster starts.
+	DependsOn []int64
+
+	// If set, failed instances are retried automatically.
+	Retry *RetryPolicy
+
+	// Overrides the resources declared by the kernel, field by field.
+	Resources *Resources
+
+	// Added to the environment of every instance, replacing variables of the
+	// same name declared by the kernel. Use SecretRef for sensitive values.
+	Env map[string]string
+}
+
+type ListClustersResponse struct {
+	Response
+	Clusters []Cluster `json:"clusters"`
 }
 
 type CreateClusterResponse struct {
@@ -69,11 +161,15 @@ type CreateClusterResponse struct {
 
 // Retreives information related to the cluster
 func (client *HttpClient) GetCluster(id int64) (Cluster, error) {
+	return client.GetClusterContext(context.Background(), id)
+}
+
+func (client *HttpClient) GetClusterContext(ctx context.Context, id int64) (Cluster, error) {
 	var output Cluster
 
 	// Get and unmarshal
 	addr := fmt.Sprintf("%s%d", ClustersEndpoint, id)
-	err := client.DoEmptyMethodAndUnmarshal("GET", addr, &output)
+	err := client.DoEmptyMethodAndUnmarshalContext(ctx, "GET", addr, &output)
 	if err != nil {
 		return output, err
 	}
@@ -82,41 +178,118 @@ func (client *HttpClient) GetCluster(id int64) (Cluster, error) {
 		return output, errors.New("Failed to get cluster information: " + output.Response.Description)
 	}
 
-	output.Id, err = strconv.ParseInt(output.IdString, 10, 64)
+	err = output.parseIds()
+	return output, err
+}
+
+// Lists the clusters of a project, or of every project accessible with the
+// token if project is empty.
+func (client *HttpClient) ListClusters(ctx context.Context, project string) ([]Cluster, error) {
+	var response ListClustersResponse
+
+	// Get and unmarshal
+	addr := ClustersEndpoint
+	if project != "" {
+		addr += "?project=" + neturl.QueryEscape(project)
+	}
+	err := client.DoEmptyMethodAndUnmarshalContext(ctx, "GET", addr, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.Code != common.Success {
+		return nil, errors.New("Failed to list clusters: " + response.Description)
+	}
+
+	for index := range response.Clusters {
+		err = response.Clusters[index].parseIds()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return response.Clusters, nil
+}
+
+func (cluster *Cluster) parseIds() error {
+	var err error
+	cluster.Id, err = strconv.ParseInt(cluster.IdString, 10, 64)
 	if err != nil {
-		return output, err
+		return err
 	}
 
-	output.Clusters = make([]int64, len(output.ClustersString))
-	for index, str := range output.ClustersString {
-		output.Clusters[index], err = strconv.ParseInt(str, 10, 64)
+	cluster.Clusters = make([]int64, len(cluster.ClustersString))
+	for index, str := range cluster.ClustersString {
+		cluster.Clusters[index], err = strconv.ParseInt(str, 10, 64)
 		if err != nil {
-			return output, err
+			return err
 		}
 	}
 
-	output.Instances = make([]int64, len(output.InstancesString))
-	for index, str := range output.InstancesString {
-		output.Instances[index], err = strconv.ParseInt(str, 10, 64)
+	cluster.Instances = make([]int64, len(cluster.InstancesString))
+	for index, str := range cluster.InstancesString {
+		cluster.Instances[index], err = strconv.ParseInt(str, 10, 64)
 		if err != nil {
-			return output, err
+			return err
 		}
 	}
 
-	return output, nil
+	return nil
 }
 
+// Creates a batch cluster. A random idempotency key is generated for the
+// request, so a creation that failed in transit is retried without risk of
+// creating the cluster twice.
 func (client *HttpClient) CreateBatchCluster(name, project string, interfaces map[string]string) (Cluster, error) {
-	request := CreateClusterRequest{
-		Type:       TypeStrings[BatchClusterType],
-		Name:       name,
-		Project:    project,
-		Interfaces: interfaces,
+	return client.CreateBatchClusterWithKey("", name, project, interfaces)
+}
+
+// Creates a batch cluster using the caller supplied idempotency key. Calling
+// this again with the same key returns the cluster created by the first call.
+func (client *HttpClient) CreateBatchClusterWithKey(key, name, project string, interfaces map[string]string) (Cluster, error) {
+	return client.CreateCluster(context.Background(), ClusterSpec{
+		Type:           BatchClusterType,
+		Name:           name,
+		Project:        project,
+		Interfaces:     interfaces,
+		IdempotencyKey: key,
+	})
+}
+
+// Creates a cluster of any type and returns its information.
+func (client *HttpClient) CreateCluster(ctx context.Context, spec ClusterSpec) (Cluster, error) {
+	err := spec.Validate()
+	if err != nil {
+		return Cluster{}, err
 	}
 
-	// Post and unmarshal response
+	request := spec.request()
+	if request.IdempotencyKey == "" {
+		request.IdempotencyKey, err = NewIdempotencyKey()
+		if err != nil {
+			return Cluster{}, err
+		}
+	}
+
+	// Post and unmarshal response, retrying requests that never got an answer
+	// or that the server failed to handle
 	var response CreateClusterResponse
-	err := client.DoMethodAndUnmarshal("POST", ClustersEndpoint, &request, &response)
+	for attempt := 0; attempt < CreateClusterAttempts; attempt++ {
+		if attempt > 0 {
+			client.Logf("Retrying cluster creation (%s): %s\n", request.IdempotencyKey, err)
+			select {
+			case <-ctx.Done():
+				return Cluster{}, ctx.Err()
+			case <-time.After(SpinSleepDuration):
+			}
+		}
+
+		var retry bool
+		response, retry, err = client.postCluster(withRetry(ctx, attempt), request)
+		if !retry || ctx.Err() != nil {
+			break
+		}
+	}
 	if err != nil {
 		return Cluster{}, err
 	}
@@ -130,7 +303,116 @@ func (client *HttpClient) CreateBatchCluster(name, project string, interfaces ma
 	if err != nil {
 		return Cluster{}, err
 	}
-	return client.GetCluster(clusterId)
+	return client.GetClusterContext(ctx, clusterId)
+}
+
+// Sends a cluster creation request. retry is set for transport errors and 5xx
+// responses, which creating the cluster again with the same idempotency key
+// may get past.
+func (client *HttpClient) postCluster(ctx context.Context, request CreateClusterRequest) (response CreateClusterResponse, retry bool, err error) {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return response, false, err
+	}
+
+	req, err := client.prepRequest(ctx, "POST", client.NewURL(ClustersEndpoint), bytes.NewReader(data))
+	if err != nil {
+		return response, false, err
+	}
+
+	resp, data, err := client.doAndReadResponse(req)
+	if err != nil {
+		return response, true, err
+	}
+	if resp.StatusCode >= 500 {
+		return response, true, fmt.Errorf("Cluster creation failed: %s", resp.Status)
+	}
+
+	err = json.Unmarshal(data, &response)
+	return response, false, err
+}
+
+// Converts the spec to the request sent to create it.
+func (spec ClusterSpec) request() CreateClusterRequest {
+	request := CreateClusterRequest{
+		Type:           TypeStrings[spec.Type],
+		Name:           spec.Name,
+		Project:        spec.Project,
+		Interfaces:     spec.Interfaces,
+		IdempotencyKey: spec.IdempotencyKey,
+		Replicas:       spec.Replicas,
+		RestartOnExit:  spec.RestartOnExit,
+		Count:          spec.Count,
+		Resources:      spec.Resources,
+		Env:            spec.Env,
+	}
+	if spec.Retry != nil {
+		outRetry := RetryPolicyToOutRetryPolicy(*spec.Retry)
+		request.Retry = &outRetry
+	}
+	for _, id := range spec.DependsOn {
+		request.DependsOn = append(request.DependsOn, strconv.FormatInt(id, 10))
+	}
+	return request
+}
+
+// Checks that the options set on the spec make sense for its type.
+func (spec ClusterSpec) Validate() error {
+	switch spec.Type {
+	case BatchClusterType:
+	case ServiceClusterType:
+		if spec.Replicas < 1 {
+			return ErrInvalidReplicas
+		}
+	case ArrayClusterType:
+		if spec.Count < 1 {
+			return ErrInvalidArrayCount
+		}
+	default:
+		return ErrInvalidClusterType
+	}
+
+	if spec.Type != ServiceClusterType && (spec.Replicas != 0 || spec.RestartOnExit) {
+		return ErrServiceOnlyOption
+	}
+	if spec.Type != ArrayClusterType && spec.Count != 0 {
+		return ErrArrayOnlyOption
+	}
+	if _, _, err := ParseProjectRef(spec.Project); err != nil {
+		return err
+	}
+	if spec.Resources != nil {
+		if err := spec.Resources.Validate(); err != nil {
+			return err
+		}
+	}
+	if spec.Retry != nil {
+		return spec.Retry.Validate()
+	}
+
+	return nil
+}
+
+// Returns the index of the running kernel within its array cluster. Only
+// meaningful when called from within an instance of an array cluster.
+func ArrayIndex() (int, error) {
+	value, ok := os.LookupEnv(ArrayIndexEnv)
+	if !ok {
+		return 0, errors.New("Not running within an array cluster")
+	}
+
+	return strconv.Atoi(value)
+}
+
+// Generates a random key suitable for CreateClusterRequest.IdempotencyKey.
+func NewIdempotencyKey() (string, error) {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
 }
 
 func (client *HttpClient) WaitForClusterToFinish(id int64, seconds time.Duration) error {
@@ -0,0 +1,66 @@
+// Command titanium-exporter serves the state of Titanium clusters and
+// instances as Prometheus metrics.
+//
+// Usage:
+//
+//	titanium-exporter [-endpoint URL] [-token TOKEN] [-project NAME] [-listen ADDR] [-interval DURATION]
+//
+// Metrics are served on /metrics, see package titaniumprom for the list.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/atomosio/titanium-go"
+	"github.com/atomosio/titanium-go/titaniumprom"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func main() {
+	endpoint := flag.String("endpoint", os.Getenv("TITANIUM_ENDPOINT"), "URL of the Titanium API")
+	token := flag.String("token", os.Getenv("TITANIUM_TOKEN"), "token used to authenticate")
+	project := flag.String("project", "", "only export clusters of this project")
+	listen := flag.String("listen", ":9464", "address to serve metrics on")
+	interval := flag.Duration("interval", 30*time.Second, "time between polls of the Titanium API")
+	verbose := flag.Bool("v", false, "log requests to the Titanium API")
+	flag.Parse()
+
+	client := titanium.NewHttpClient(*endpoint, *token)
+	if *verbose {
+		client.StartLogging()
+	}
+
+	exporter := titaniumprom.NewExporter(client, *project)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		exporter,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go exporter.Run(ctx, *interval)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	server := &http.Server{Addr: *listen, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+
+	err := server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		fmt.Fprintf(os.Stderr, "titanium-exporter: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,70 @@
+// Command titanium is a command line client for the Titanium service.
+//
+// Usage:
+//
+//	titanium [-endpoint URL] [-token TOKEN] <command> [arguments]
+//
+// Commands:
+//
+//	push      uploads a kernel described by the titanium.yaml in a directory
+//	render    renders a system project as a Graphviz DOT or Mermaid graph
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/atomosio/titanium-go"
+)
+
+type command struct {
+	usage string
+	run   func(client *titanium.HttpClient, args []string) error
+}
+
+var commands = map[string]command{
+	"push":   {"[dir]", push},
+	"render": {"[-format dot|mermaid] [-kernels=false] (-file definition.json | project)", render},
+}
+
+func main() {
+	endpoint := flag.String("endpoint", os.Getenv("TITANIUM_ENDPOINT"), "URL of the Titanium API")
+	token := flag.String("token", os.Getenv("TITANIUM_TOKEN"), "token used to authenticate")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "titanium: unknown command %q\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
+	client := titanium.NewHttpClient(*endpoint, *token)
+	err := cmd.run(client, flag.Args()[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "titanium %s: %s\n", flag.Arg(0), err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: titanium [flags] <command> [arguments]\n\nCommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nFlags:\n")
+	flag.PrintDefaults()
+}
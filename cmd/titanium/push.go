@@ -0,0 +1,20 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	"github.com/atomosio/titanium-go"
+)
+
+func push(client *titanium.HttpClient, args []string) error {
+	flags := flag.NewFlagSet("push", flag.ExitOnError)
+	flags.Parse(args)
+
+	dir := "."
+	if flags.NArg() > 0 {
+		dir = flags.Arg(0)
+	}
+
+	return client.PushKernel(context.Background(), dir)
+}
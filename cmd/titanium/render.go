@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"os"
+
+	"github.com/atomosio/titanium-go"
+)
+
+func render(client *titanium.HttpClient, args []string) error {
+	flags := flag.NewFlagSet("render", flag.ExitOnError)
+	format := flags.String("format", titanium.DotFormat, "output format, dot or mermaid")
+	file := flags.String("file", "", "read the project definition from a JSON file instead of the server")
+	kernels := flags.Bool("kernels", true, "fetch referenced kernels to resolve interface directions and types")
+	flags.Parse(args)
+
+	ctx := context.Background()
+	var definition titanium.ProjectDefinition
+	switch {
+	case *file != "":
+		data, err := ioutil.ReadFile(*file)
+		if err != nil {
+			return err
+		}
+		err = json.Unmarshal(data, &definition)
+		if err != nil {
+			return err
+		}
+	case flags.NArg() == 1:
+		var err error
+		definition, err = client.GetProjectDefinition(ctx, flags.Arg(0))
+		if err != nil {
+			return err
+		}
+	default:
+		return errors.New("expected a project name or -file")
+	}
+
+	var lookup titanium.KernelLookup
+	if *kernels {
+		lookup = client.KernelLookup(ctx)
+	}
+
+	output, err := titanium.RenderSystemWithKernels(definition, *format, lookup)
+	if err != nil {
+		return err
+	}
+
+	_, err = os.Stdout.WriteString(output)
+	return err
+}
@@ -0,0 +1,200 @@
+package titanium
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrCronNoFireTime = errors.New("Cron expression never fires")
+)
+
+// A parsed five field cron expression: minute, hour, day of month, month and
+// day of week. Each field is a bit set of the values it matches.
+type CronExpression struct {
+	minute, hour, dom, month, dow uint64
+
+	// Vixie cron semantics: when both day fields are restricted, a day matches
+	// if either of them does.
+	domStar, dowStar bool
+}
+
+type cronField struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	cronMinute = cronField{0, 59, nil}
+	cronHour   = cronField{0, 23, nil}
+	cronDom    = cronField{1, 31, nil}
+	cronMonth  = cronField{1, 12, map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	cronDow = cronField{0, 7, map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+
+	cronMacros = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+)
+
+// Parses a standard five field cron expression. Fields accept '*', values,
+// ranges ('1-5'), lists ('1,3') and steps ('*/15', '0-30/5'). Months and days
+// of the week may be given by their three letter names, and the usual
+// '@daily' style macros are understood.
+func ParseCron(expression string) (CronExpression, error) {
+	var output CronExpression
+
+	expression = strings.TrimSpace(expression)
+	if macro, ok := cronMacros[strings.ToLower(expression)]; ok {
+		expression = macro
+	}
+
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return output, fmt.Errorf("Cron expression %q must have 5 fields", expression)
+	}
+
+	var err error
+	if output.minute, err = cronMinute.parse(fields[0]); err != nil {
+		return output, err
+	}
+	if output.hour, err = cronHour.parse(fields[1]); err != nil {
+		return output, err
+	}
+	if output.dom, err = cronDom.parse(fields[2]); err != nil {
+		return output, err
+	}
+	if output.month, err = cronMonth.parse(fields[3]); err != nil {
+		return output, err
+	}
+	if output.dow, err = cronDow.parse(fields[4]); err != nil {
+		return output, err
+	}
+
+	// Sunday may be written as 0 or 7
+	if output.dow&(1<<7) != 0 {
+		output.dow |= 1
+	}
+	output.domStar = fields[2] == "*" || fields[2] == "?"
+	output.dowStar = fields[4] == "*" || fields[4] == "?"
+
+	return output, nil
+}
+
+func (field cronField) parse(text string) (uint64, error) {
+	var output uint64
+
+	for _, part := range strings.Split(text, ",") {
+		step := 1
+		if index := strings.Index(part, "/"); index >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[index+1:])
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("Invalid cron step in %q", part)
+			}
+			part = part[:index]
+		}
+
+		low, high := field.min, field.max
+		if part != "*" && part != "?" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			low, err = field.value(bounds[0])
+			if err != nil {
+				return 0, err
+			}
+			high = low
+			if len(bounds) == 2 {
+				high, err = field.value(bounds[1])
+				if err != nil {
+					return 0, err
+				}
+			} else if step != 1 {
+				// '5/15' means starting at 5 until the end of the range
+				high = field.max
+			}
+		}
+		if low > high {
+			return 0, fmt.Errorf("Invalid cron range %q", part)
+		}
+
+		for value := low; value <= high; value += step {
+			output |= 1 << uint(value)
+		}
+	}
+
+	return output, nil
+}
+
+func (field cronField) value(text string) (int, error) {
+	if value, ok := field.names[strings.ToLower(text)]; ok {
+		return value, nil
+	}
+
+	value, err := strconv.Atoi(text)
+	if err != nil || value < field.min || value > field.max {
+		return 0, fmt.Errorf("Invalid cron value %q, must be within %d-%d", text, field.min, field.max)
+	}
+	return value, nil
+}
+
+func (expression CronExpression) dayMatches(t time.Time) bool {
+	domMatch := expression.dom&(1<<uint(t.Day())) != 0
+	dowMatch := expression.dow&(1<<uint(t.Weekday())) != 0
+
+	if expression.domStar || expression.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// Returns the first time strictly after t at which the expression fires, in
+// the location of t. Wall clock times skipped by a daylight saving change never
+// fire.
+func (expression CronExpression) Next(t time.Time) (time.Time, error) {
+	location := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	// Five years covers every valid expression, including leap days
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if expression.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, location)
+			continue
+		}
+		if !expression.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, location)
+			continue
+		}
+		if expression.hour&(1<<uint(t.Hour())) == 0 {
+			next := time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, location)
+			if !next.After(t) {
+				// Wall clock went backwards, step over the repeated hour
+				next = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+			}
+			t = next
+			continue
+		}
+		if expression.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t, nil
+	}
+
+	return time.Time{}, ErrCronNoFireTime
+}
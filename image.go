@@ -0,0 +1,213 @@
+package titanium
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/atomosio/common"
+)
+
+const (
+	ImagesEndpoint = "images/"
+
+	// Image used by kernels that do not name one
+	DefaultImage = "default"
+	// Separates an image name from the digest pinning it
+	ImageDigestSeparator = "@"
+)
+
+var (
+	ErrInvalidImageDigest = errors.New("Invalid image digest, expected sha256:<64 hex digits>")
+	ErrNotOCILayout       = errors.New("Tarball is not an OCI image layout")
+
+	digestRegexp = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+)
+
+// A container image kernels can run in.
+type Image struct {
+	Name        string `json:"name"`
+	Digest      string `json:"digest"`
+	Description string `json:"description,omitempty"`
+	// Unix timestamp
+	Created int64 `json:"created"`
+	Size    int64 `json:"size"`
+	// Tools installed in the image, mapped to their versions
+	Tooling map[string]string `json:"tooling,omitempty"`
+}
+
+type GetImageResponse struct {
+	Response
+	Image Image `json:"image"`
+}
+
+type ListImagesResponse struct {
+	Response
+	Images []Image `json:"images"`
+}
+
+// Splits an image reference of the form 'name' or 'name@sha256:...' into its
+// name and digest.
+func ParseImageRef(ref string) (name, digest string, err error) {
+	name = ref
+	if index := strings.Index(ref, ImageDigestSeparator); index >= 0 {
+		name, digest = ref[:index], ref[index+1:]
+		if !digestRegexp.MatchString(digest) {
+			return "", "", ErrInvalidImageDigest
+		}
+	}
+
+	if name == "" {
+		return "", "", fmt.Errorf("Invalid image reference %q", ref)
+	}
+	return name, digest, nil
+}
+
+// Returns a reference pinning the image to its current digest.
+func (image Image) Ref() string {
+	return image.Name + ImageDigestSeparator + image.Digest
+}
+
+func (client *HttpClient) ListImages(ctx context.Context) ([]Image, error) {
+	var response ListImagesResponse
+
+	// Get and unmarshal
+	err := client.DoEmptyMethodAndUnmarshalContext(ctx, "GET", ImagesEndpoint, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.Code != common.Success {
+		return nil, errors.New(response.Description)
+	}
+
+	return response.Images, nil
+}
+
+// Retreives an image by name, optionally pinned to a digest. A pinned
+// reference only matches if the image has that digest.
+func (client *HttpClient) GetImage(ctx context.Context, ref string) (Image, error) {
+	var response GetImageResponse
+
+	name, digest, err := ParseImageRef(ref)
+	if err != nil {
+		return Image{}, err
+	}
+
+	// Get and unmarshal
+	addr := ImagesEndpoint + name
+	if digest != "" {
+		addr += "/digests/" + digest
+	}
+	err = client.DoEmptyMethodAndUnmarshalContext(ctx, "GET", addr, &response)
+	if err != nil {
+		return Image{}, err
+	}
+
+	if response.Code != common.Success {
+		return Image{}, fmt.Errorf("Unknown image %q: %s", ref, response.Description)
+	}
+	if digest != "" && response.Image.Digest != digest {
+		return Image{}, fmt.Errorf("Image %q has digest %s", ref, response.Image.Digest)
+	}
+
+	return response.Image, nil
+}
+
+// Uploads an image from a tarball of an OCI image layout, such as the ones
+// written by 'docker buildx build --output type=oci' or 'skopeo copy', and
+// publishes it under name.
+func (client *HttpClient) PushImage(ctx context.Context, name, tarball string) (Image, error) {
+	if _, _, err := ParseImageRef(name); err != nil || strings.Contains(name, ImageDigestSeparator) {
+		return Image{}, fmt.Errorf("Invalid image name %q", name)
+	}
+
+	file, err := os.Open(tarball)
+	if err != nil {
+		return Image{}, err
+	}
+	defer file.Close()
+
+	err = checkOCILayout(file)
+	if err != nil {
+		return Image{}, err
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return Image{}, err
+	}
+
+	// Put and unmarshal response
+	var response GetImageResponse
+	err = client.DoRawMethodAndUnmarshalContext(ctx, "PUT", ImagesEndpoint+name, "application/x-tar", file, &response)
+	if err != nil {
+		return Image{}, err
+	}
+
+	if response.Code != common.Success {
+		return Image{}, errors.New(response.Description)
+	}
+
+	return response.Image, nil
+}
+
+// Checks the tarball holds the files every OCI image layout has.
+func checkOCILayout(r io.Reader) error {
+	var hasLayout, hasIndex bool
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", ErrNotOCILayout, err)
+		}
+
+		switch strings.TrimPrefix(header.Name, "./") {
+		case "oci-layout":
+			hasLayout = true
+		case "index.json":
+			hasIndex = true
+		}
+	}
+
+	if !hasLayout || !hasIndex {
+		return ErrNotOCILayout
+	}
+	return nil
+}
+
+// Checks that the image the kernel runs in exists, and matches its digest if
+// pinned. Kernels are checked this way before being set on a project.
+func (client *HttpClient) ValidateKernelImage(ctx context.Context, kernel Kernel) error {
+	if kernel.Image == "" {
+		return nil
+	}
+
+	_, err := client.GetImage(ctx, kernel.Image)
+	return err
+}
+
+// Returns a copy of the kernel with its image pinned to the digest it
+// currently has.
+func (client *HttpClient) PinKernelImage(ctx context.Context, kernel Kernel) (Kernel, error) {
+	ref := kernel.Image
+	if ref == "" {
+		ref = DefaultImage
+	}
+
+	image, err := client.GetImage(ctx, ref)
+	if err != nil {
+		return kernel, err
+	}
+
+	kernel.Image = image.Ref()
+	return kernel, nil
+}
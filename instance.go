@@ -1,6 +1,7 @@
 package titanium
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strconv"
@@ -23,6 +24,14 @@ type Instance struct {
 	StderrString string     `json:"stderr"`
 	Status       string     `json:"status"`
 	Log          []LogEntry `json:"log"`
+
+	// Position of the instance within an array cluster
+	Index int `json:"index"`
+
+	// Starting at 1, incremented each time a failed instance is retried
+	Attempt int `json:"attempt"`
+	// Id of the failed instance this one retries, if any
+	RetryOf string `json:"retry_of,omitempty"`
 }
 
 type LogEntry struct {
@@ -72,23 +81,43 @@ var (
 		"Error",
 		"Log",
 		"Shutdown",
+		"Retry",
+		"OutOfMemory",
+		"DiskLimit",
+		"Timeout",
+	}
+
+	// Events logged when the platform terminates an instance for exceeding
+	// one of its resource limits
+	LimitEventStrings = []string{
+		"OutOfMemory",
+		"DiskLimit",
+		"Timeout",
 	}
 )
 
 // Retreives the instance information associated with the token this client was
 // created with.
 func (client *HttpClient) GetTokenInstance() (Instance, error) {
-	return client.GetInstance(0)
+	return client.GetTokenInstanceContext(context.Background())
+}
+
+func (client *HttpClient) GetTokenInstanceContext(ctx context.Context) (Instance, error) {
+	return client.GetInstanceContext(ctx, 0)
 }
 
 func (client *HttpClient) SetInstanceActive(instanceId int64) error {
+	return client.SetInstanceActiveContext(context.Background(), instanceId)
+}
+
+func (client *HttpClient) SetInstanceActiveContext(ctx context.Context, instanceId int64) error {
 	// Get and unmarshal
 	request := UpdateInstanceRequest{
 		Status: InstanceActiveStatus,
 	}
 	response := &Response{}
 	addr := fmt.Sprintf("%s%d", InstancesEndpoint, instanceId)
-	err := client.DoMethodAndUnmarshal("PATCH", addr, request, response)
+	err := client.DoMethodAndUnmarshalContext(ctx, "PATCH", addr, request, response)
 	if err != nil {
 		return err
 	}
@@ -100,13 +129,17 @@ func (client *HttpClient) SetInstanceActive(instanceId int64) error {
 }
 
 func (client *HttpClient) SetInstanceStopped(instanceId int64) error {
+	return client.SetInstanceStoppedContext(context.Background(), instanceId)
+}
+
+func (client *HttpClient) SetInstanceStoppedContext(ctx context.Context, instanceId int64) error {
 	// Get and unmarshal
 	request := UpdateInstanceRequest{
 		Status: InstanceStoppedStatus,
 	}
 	response := &Response{}
 	addr := fmt.Sprintf("%s%d", InstancesEndpoint, instanceId)
-	err := client.DoMethodAndUnmarshal("PATCH", addr, request, response)
+	err := client.DoMethodAndUnmarshalContext(ctx, "PATCH", addr, request, response)
 	if err != nil {
 		return err
 	}
@@ -118,13 +151,17 @@ func (client *HttpClient) SetInstanceStopped(instanceId int64) error {
 }
 
 func (client *HttpClient) LogInstanceComment(instanceId int64, comment string) error {
+	return client.LogInstanceCommentContext(context.Background(), instanceId, comment)
+}
+
+func (client *HttpClient) LogInstanceCommentContext(ctx context.Context, instanceId int64, comment string) error {
 	// Get and unmarshal
 	request := UpdateInstanceRequest{
-		Log: comment,
+		Log: client.redact(comment),
 	}
 	response := &Response{}
 	addr := fmt.Sprintf("%s%d", InstancesEndpoint, instanceId)
-	err := client.DoMethodAndUnmarshal("PATCH", addr, request, response)
+	err := client.DoMethodAndUnmarshalContext(ctx, "PATCH", addr, request, response)
 	if err != nil {
 		return err
 	}
@@ -136,13 +173,17 @@ func (client *HttpClient) LogInstanceComment(instanceId int64, comment string) e
 }
 
 func (client *HttpClient) LogInstanceError(instanceId int64, comment string) error {
+	return client.LogInstanceErrorContext(context.Background(), instanceId, comment)
+}
+
+func (client *HttpClient) LogInstanceErrorContext(ctx context.Context, instanceId int64, comment string) error {
 	// Get and unmarshal
 	request := UpdateInstanceRequest{
-		Error: comment,
+		Error: client.redact(comment),
 	}
 	response := &Response{}
 	addr := fmt.Sprintf("%s%d", InstancesEndpoint, instanceId)
-	err := client.DoMethodAndUnmarshal("PATCH", addr, request, response)
+	err := client.DoMethodAndUnmarshalContext(ctx, "PATCH", addr, request, response)
 	if err != nil {
 		return err
 	}
@@ -154,11 +195,15 @@ func (client *HttpClient) LogInstanceError(instanceId int64, comment string) err
 }
 
 func (client *HttpClient) GetInstance(instanceId int64) (Instance, error) {
+	return client.GetInstanceContext(context.Background(), instanceId)
+}
+
+func (client *HttpClient) GetInstanceContext(ctx context.Context, instanceId int64) (Instance, error) {
 	var output Instance
 
 	// Get and unmarshal
 	addr := fmt.Sprintf("%s%d", InstancesEndpoint, instanceId)
-	err := client.DoEmptyMethodAndUnmarshal("GET", addr, &output)
+	err := client.DoEmptyMethodAndUnmarshalContext(ctx, "GET", addr, &output)
 	if err != nil {
 		return output, err
 	}
@@ -167,6 +212,10 @@ func (client *HttpClient) GetInstance(instanceId int64) (Instance, error) {
 	}
 	// TODO Check reponse to make sure operation succeeded
 
+	for index := range output.Log {
+		output.Log[index].Comment = client.redact(output.Log[index].Comment)
+	}
+
 	output.Stderr, err = strconv.ParseInt(output.StderrString, 10, 64)
 	if err != nil {
 		return output, err
@@ -214,6 +263,55 @@ func (instance Instance) IsStopped() bool {
 	return instance.Status == InstanceStatusStrings[InstanceStoppedStatus]
 }
 
+// Returns whether the instance stopped after logging an error.
+func (instance Instance) IsFailed() bool {
+	return instance.IsStopped() && len(instance.Errors()) > 0
+}
+
+// Returns the error events of the instance log, including resource limit
+// terminations.
+func (instance Instance) Errors() []LogEntry {
+	return instance.entries(append([]string{"Error"}, LimitEventStrings...)...)
+}
+
+// Returns the event logged if the instance was terminated for exceeding one
+// of its resource limits.
+func (instance Instance) LimitExceeded() (LogEntry, bool) {
+	entries := instance.entries(LimitEventStrings...)
+	if len(entries) == 0 {
+		return LogEntry{}, false
+	}
+	return entries[len(entries)-1], true
+}
+
+// Returns the retry events of the instance log, one for every earlier attempt.
+func (instance Instance) Retries() []LogEntry {
+	return instance.entries("Retry")
+}
+
+// Returns the time of the first event of the given type, e.g. "Queued",
+// "Started" or "Stopped", found in the instance log.
+func (instance Instance) EventTime(eventType string) (time.Time, bool) {
+	entries := instance.entries(eventType)
+	if len(entries) == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(entries[0].Timestamp, 0), true
+}
+
+func (instance Instance) entries(eventTypes ...string) []LogEntry {
+	var output []LogEntry
+	for _, entry := range instance.Log {
+		for _, eventType := range eventTypes {
+			if entry.Type == eventType {
+				output = append(output, entry)
+				break
+			}
+		}
+	}
+	return output
+}
+
 func (instance Instance) IsShuttingDown() bool {
 	shutDownEventLast := false
 
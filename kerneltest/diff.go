@@ -0,0 +1,115 @@
+package kerneltest
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Lines of context shown around each change
+const diffContext = 3
+
+// Inputs with more lines than this are not diffed line by line
+const maxDiffLines = 4000
+
+// Returns a line based diff turning want into got, in the style of a unified
+// diff. Binary content is summarized instead.
+func Diff(want, got []byte) string {
+	if !utf8.Valid(want) || !utf8.Valid(got) || bytes.IndexByte(want, 0) >= 0 || bytes.IndexByte(got, 0) >= 0 {
+		return binarySummary(want, got)
+	}
+
+	a := splitLines(string(want))
+	b := splitLines(string(got))
+	if len(a) > maxDiffLines || len(b) > maxDiffLines {
+		return fmt.Sprintf("--- golden (%d lines)\n+++ actual (%d lines)\n(too large to diff)\n", len(a), len(b))
+	}
+
+	// Longest common subsequence of lines
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	type line struct {
+		op   byte
+		text string
+	}
+	var lines []line
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, line{' ', a[i]})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, line{'-', a[i]})
+			i++
+		default:
+			lines = append(lines, line{'+', b[j]})
+			j++
+		}
+	}
+
+	// Only keep changes and the context around them
+	var buf bytes.Buffer
+	buf.WriteString("--- golden\n+++ actual\n")
+	lastShown := -1
+	for index, l := range lines {
+		near := false
+		for k := index - diffContext; k <= index+diffContext; k++ {
+			if k >= 0 && k < len(lines) && lines[k].op != ' ' {
+				near = true
+				break
+			}
+		}
+		if !near {
+			continue
+		}
+		if lastShown >= 0 && index > lastShown+1 {
+			buf.WriteString("@@ ... @@\n")
+		}
+		buf.WriteByte(l.op)
+		buf.WriteString(l.text)
+		buf.WriteByte('\n')
+		lastShown = index
+	}
+
+	return buf.String()
+}
+
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	for index, l := range lines {
+		if strings.HasSuffix(l, "\n") {
+			lines[index] = l[:len(l)-1]
+		} else {
+			lines[index] = l + " (no newline at end of file)"
+		}
+	}
+	return lines
+}
+
+func binarySummary(want, got []byte) string {
+	offset := 0
+	for offset < len(want) && offset < len(got) && want[offset] == got[offset] {
+		offset++
+	}
+	return fmt.Sprintf("binary content differs: golden is %d bytes, actual is %d bytes, first difference at byte %d\n", len(want), len(got), offset)
+}
@@ -0,0 +1,210 @@
+// Package kerneltest runs kernels locally from Go tests and compares their
+// outputs against golden files.
+//
+// A typical test:
+//
+//	func TestClean(t *testing.T) {
+//		kerneltest.Run(t, kerneltest.Case{
+//			Dir:    "..",
+//			Inputs: map[string]string{"in": "testdata/in.csv"},
+//			Golden: map[string]string{"out": "testdata/out.golden.csv"},
+//		})
+//	}
+//
+// Running the tests with -kerneltest.update rewrites the golden files from
+// the actual outputs.
+package kerneltest
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/atomosio/titanium-go"
+)
+
+// Namespaced so as not to clash with the -update flag of other packages
+var update = flag.Bool("kerneltest.update", false, "update kernel golden files")
+
+// Describes a single local run of a kernel and its expected outcome.
+type Case struct {
+	// Kernel source directory, used as the working directory
+	Dir string
+	// Kernel to run. If it has no command, it is loaded from the titanium.yaml
+	// manifest in Dir.
+	Kernel titanium.Kernel
+
+	// Interface names mapped to fixture paths, or to values for parameters
+	// and secrets
+	Inputs map[string]string
+	// Output interface names mapped to golden files, or golden directories for
+	// directory interfaces
+	Golden map[string]string
+	// Expected exit status of the command
+	ExitCode int
+	// Whether the kernel is expected to exceed its wall clock limit
+	TimedOut bool
+}
+
+// Runs the kernel of the case and fails t if its exit status or outputs do
+// not match the expectations.
+func Run(t testing.TB, c Case) *Result {
+	t.Helper()
+
+	kernel := c.Kernel
+	if kernel.Command == "" {
+		manifest, err := titanium.LoadKernelManifest(c.Dir)
+		if err != nil {
+			t.Fatalf("Failed to load kernel manifest: %s", err)
+		}
+		kernel, err = manifest.Kernel()
+		if err != nil {
+			t.Fatalf("Invalid kernel manifest: %s", err)
+		}
+	}
+
+	result, err := RunKernel(context.Background(), kernel, c.Dir, c.Inputs)
+	if err != nil {
+		t.Fatalf("Failed to run kernel: %s", err)
+	}
+	t.Cleanup(result.Cleanup)
+
+	if result.TimedOut != c.TimedOut {
+		t.Errorf("Kernel timed out: %t, expected %t", result.TimedOut, c.TimedOut)
+	} else if !result.TimedOut && result.ExitCode != c.ExitCode {
+		t.Errorf("Kernel exited with status %d, expected %d\nstdout:\n%s\nstderr:\n%s", result.ExitCode, c.ExitCode, result.Stdout, result.Stderr)
+	}
+
+	names := make([]string, 0, len(c.Golden))
+	for name := range c.Golden {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		actual, ok := result.Outputs[name]
+		if !ok {
+			t.Errorf("Kernel has no output interface %q", name)
+			continue
+		}
+
+		if *update {
+			err = updateGolden(actual, c.Golden[name])
+			if err != nil {
+				t.Errorf("Failed to update golden %q: %s", c.Golden[name], err)
+			}
+			continue
+		}
+
+		problems, err := compare(actual, c.Golden[name])
+		if err != nil {
+			t.Errorf("Output %q: %s", name, err)
+			continue
+		}
+		for _, problem := range problems {
+			t.Errorf("Output %q: %s", name, problem)
+		}
+	}
+
+	return result
+}
+
+func updateGolden(actual, golden string) error {
+	err := os.RemoveAll(golden)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(golden), 0755)
+	if err != nil {
+		return err
+	}
+	return copyPath(actual, golden)
+}
+
+// Compares an output file or tree with its golden counterpart.
+func compare(actual, golden string) ([]string, error) {
+	info, err := os.Stat(golden)
+	if err != nil {
+		return nil, fmt.Errorf("%s (run with -kerneltest.update to create it)", err)
+	}
+	if !info.IsDir() {
+		problem, err := compareFile(actual, golden)
+		if err != nil || problem == "" {
+			return nil, err
+		}
+		return []string{problem}, nil
+	}
+
+	actualFiles, err := listFiles(actual)
+	if err != nil {
+		return nil, err
+	}
+	goldenFiles, err := listFiles(golden)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	for rel := range goldenFiles {
+		if !actualFiles[rel] {
+			problems = append(problems, fmt.Sprintf("missing file %s", rel))
+			continue
+		}
+		problem, err := compareFile(filepath.Join(actual, rel), filepath.Join(golden, rel))
+		if err != nil {
+			return nil, err
+		}
+		if problem != "" {
+			problems = append(problems, rel+": "+problem)
+		}
+	}
+	for rel := range actualFiles {
+		if !goldenFiles[rel] {
+			problems = append(problems, fmt.Sprintf("unexpected file %s", rel))
+		}
+	}
+
+	sort.Strings(problems)
+	return problems, nil
+}
+
+func compareFile(actual, golden string) (string, error) {
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		return "", err
+	}
+	got, err := ioutil.ReadFile(actual)
+	if os.IsNotExist(err) {
+		return "kernel did not produce the output", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if bytes.Equal(got, want) {
+		return "", nil
+	}
+	return "differs from golden file " + golden + "\n" + Diff(want, got), nil
+}
+
+func listFiles(root string) (map[string]bool, error) {
+	output := make(map[string]bool)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		output[filepath.ToSlash(rel)] = true
+		return err
+	})
+	if os.IsNotExist(err) {
+		return output, nil
+	}
+	return output, err
+}
@@ -0,0 +1,244 @@
+package kerneltest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/atomosio/titanium-go"
+)
+
+// Environment variables set for kernels run locally
+const (
+	// Root of the sandbox absolute interface paths are placed under
+	RootEnv = "TITANIUM_ROOT"
+	// Prefix of the variables holding the local path of each file and
+	// directory interface, followed by the upper cased interface name
+	InterfaceEnvPrefix = "TITANIUM_IF_"
+)
+
+var (
+	ErrStreamUnsupported = errors.New("Stream interfaces can not be run locally")
+)
+
+// Outcome of running a kernel locally.
+type Result struct {
+	ExitCode int
+	Stdout   []byte
+	Stderr   []byte
+	// Set if the kernel was stopped for exceeding its wall clock limit
+	TimedOut bool
+
+	// Sandbox the kernel ran in, removed by Cleanup
+	Root string
+	// Copy of the kernel source directory within Root the kernel ran in
+	WorkDir string
+	// Local paths of the output-capable file and directory interfaces
+	Outputs map[string]string
+}
+
+// Runs a kernel on this machine, in a copy of its source directory dir. Inputs
+// map interface names to local fixture paths for file and directory
+// interfaces, and to values for parameters and secrets.
+//
+// Everything happens in a temporary sandbox, dir is never written to. The copy
+// of dir is the working directory of the kernel, relative interface paths are
+// resolved against it and absolute ones are relocated into the sandbox. Paths
+// escaping the sandbox are rejected. The local path of every file and
+// directory interface is also exported as TITANIUM_IF_<NAME>. A non-zero exit
+// status is reported in Result.ExitCode rather than as an error. The wall
+// clock limit in the kernel resources is enforced, other limits are not.
+func RunKernel(ctx context.Context, kernel titanium.Kernel, dir string, inputs map[string]string) (*Result, error) {
+	err := kernel.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := ioutil.TempDir("", "kerneltest")
+	if err != nil {
+		return nil, err
+	}
+	result := &Result{
+		Root:    root,
+		WorkDir: filepath.Join(root, "work"),
+		Outputs: make(map[string]string),
+	}
+
+	err = copyPath(dir, result.WorkDir)
+	if err != nil {
+		result.Cleanup()
+		return nil, err
+	}
+
+	env := append(os.Environ(), RootEnv+"="+root)
+	for name, value := range kernel.Env {
+		env = append(env, name+"="+value)
+	}
+	command := kernel.Command
+	for _, kinterface := range kernel.Interfaces {
+		input, hasInput := inputs[kinterface.Name]
+		isInput := kinterface.Direction == titanium.InDirection || kinterface.Direction == titanium.InOutDirection
+		if isInput && !hasInput && !kinterface.Optional {
+			result.Cleanup()
+			return nil, fmt.Errorf("No input for interface %q", kinterface.Name)
+		}
+
+		switch kinterface.Type {
+		case titanium.FileType, titanium.DirectoryType:
+			path, err := localPath(root, result.WorkDir, kinterface.Path)
+			if err != nil {
+				result.Cleanup()
+				return nil, fmt.Errorf("Interface %q: %s", kinterface.Name, err)
+			}
+			env = append(env, InterfaceEnvPrefix+envName(kinterface.Name)+"="+path)
+
+			err = os.MkdirAll(filepath.Dir(path), 0755)
+			if err == nil && isInput && hasInput {
+				err = copyPath(input, path)
+			}
+			if err != nil {
+				result.Cleanup()
+				return nil, err
+			}
+			if kinterface.Direction != titanium.InDirection {
+				result.Outputs[kinterface.Name] = path
+			}
+
+		case titanium.ParameterType, titanium.SecretType:
+			if !hasInput {
+				continue
+			}
+			err = titanium.ValidateValue(kinterface.Kind, input)
+			if err != nil {
+				result.Cleanup()
+				return nil, fmt.Errorf("Interface %q: %s", kinterface.Name, err)
+			}
+			if kinterface.Delivery == titanium.ArgDelivery {
+				command += " " + shellQuote("--"+kinterface.Path+"="+input)
+			} else {
+				env = append(env, kinterface.Path+"="+input)
+			}
+
+		case titanium.StreamType:
+			result.Cleanup()
+			return nil, ErrStreamUnsupported
+		}
+	}
+
+	runCtx := ctx
+	if kernel.Resources != nil && kernel.Resources.Timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, time.Duration(kernel.Resources.Timeout))
+		defer cancel()
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(runCtx, "/bin/sh", "-c", command)
+	// Don't wait forever on children that kept the output pipes open
+	cmd.WaitDelay = time.Second
+	cmd.Dir = result.WorkDir
+	cmd.Env = env
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	result.Stdout = stdout.Bytes()
+	result.Stderr = stderr.Bytes()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		result.ExitCode = exitErr.ExitCode()
+		err = nil
+	}
+	if err == exec.ErrWaitDelay {
+		err = nil
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err == nil && runCtx.Err() == context.DeadlineExceeded {
+		result.TimedOut = true
+	}
+	if err != nil {
+		result.Cleanup()
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Removes the sandbox the kernel ran in.
+func (result *Result) Cleanup() {
+	os.RemoveAll(result.Root)
+}
+
+// Returns where an interface path lives within the sandbox.
+func localPath(root, workDir, path string) (string, error) {
+	local := filepath.Join(workDir, path)
+	if filepath.IsAbs(path) {
+		local = filepath.Join(root, path)
+	}
+
+	rel, err := filepath.Rel(root, local)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("Path %q is outside of the sandbox", path)
+	}
+	return local, nil
+}
+
+func envName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func shellQuote(text string) string {
+	return "'" + strings.Replace(text, "'", `'\''`, -1) + "'"
+}
+
+// Copies a file or directory tree from src to dst.
+func copyPath(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		}
+
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+
+		out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, in)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	})
+}
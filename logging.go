@@ -0,0 +1,154 @@
+package titanium
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// JSON fields whose values are never logged
+var sensitiveFields = map[string]bool{
+	"password": true,
+	"token":    true,
+	"secret":   true,
+}
+
+type retryKey struct{}
+
+// Marks the requests made with ctx as the given retry of an earlier request,
+// so it shows up in the request logs.
+func withRetry(ctx context.Context, retry int) context.Context {
+	return context.WithValue(ctx, retryKey{}, retry)
+}
+
+func retryFromContext(ctx context.Context) int {
+	retry, _ := ctx.Value(retryKey{}).(int)
+	return retry
+}
+
+// Logs every request to logger: method, URL, status, latency and retry count.
+// Failed requests are logged at warning level, the rest at debug level.
+// Tokens, passwords and registered secret values are redacted. A nil logger
+// disables logging.
+func (client *HttpClient) WithLogger(logger *slog.Logger) *HttpClient {
+	client.logger = logger
+	return client
+}
+
+// Includes request headers and request and response bodies in the logs.
+func (client *HttpClient) WithBodyLogging() *HttpClient {
+	client.logBodies = true
+	return client
+}
+
+func (client *HttpClient) doAndLog(req *http.Request) (*http.Response, error) {
+	var requestBody []byte
+	if client.logBodies && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err == nil {
+			requestBody, _ = ioutil.ReadAll(body)
+			body.Close()
+		}
+	}
+
+	start := time.Now()
+	resp, err := client.httpClient().Do(req)
+	latency := time.Since(start)
+
+	attrs := []slog.Attr{
+		slog.String("method", req.Method),
+		slog.String("url", client.redact(req.URL.String())),
+		slog.Duration("latency", latency),
+	}
+	if retry := retryFromContext(req.Context()); retry > 0 {
+		attrs = append(attrs, slog.Int("retry", retry))
+	}
+	if client.logBodies {
+		attrs = append(attrs, slog.Any("request_headers", client.redactHeader(req.Header)))
+		if len(requestBody) > 0 {
+			attrs = append(attrs, slog.String("request_body", client.redactBody(requestBody)))
+		}
+	}
+
+	level := slog.LevelDebug
+	if err != nil {
+		level = slog.LevelWarn
+		attrs = append(attrs, slog.String("error", client.redact(err.Error())))
+	} else {
+		attrs = append(attrs, slog.Int("status", resp.StatusCode))
+		if !statusGood(resp.StatusCode) {
+			level = slog.LevelWarn
+		}
+
+		if client.logBodies {
+			responseBody, readErr := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			resp.Body = ioutil.NopCloser(bytes.NewReader(responseBody))
+			if readErr != nil {
+				err = readErr
+			}
+			attrs = append(attrs, slog.String("response_body", client.redactBody(responseBody)))
+		}
+	}
+
+	client.logger.LogAttrs(req.Context(), level, "titanium request", attrs...)
+	return resp, err
+}
+
+func (client *HttpClient) redactHeader(header http.Header) http.Header {
+	output := make(http.Header, len(header))
+	for name, values := range header {
+		if name == "Authorization" || name == "Cookie" {
+			output[name] = []string{RedactedText}
+			continue
+		}
+		for _, value := range values {
+			output[name] = append(output[name], client.redact(value))
+		}
+	}
+	return output
+}
+
+// Redacts sensitive fields of JSON bodies, and registered secrets in any
+// body.
+func (client *HttpClient) redactBody(body []byte) string {
+	return client.redact(string(RedactJSON(body)))
+}
+
+// Replaces the values of password, token and secret fields found at any depth
+// of a JSON document. Anything but JSON is returned as is.
+func RedactJSON(body []byte) []byte {
+	var value interface{}
+	if json.Unmarshal(body, &value) != nil {
+		return body
+	}
+
+	redactFields(value)
+	redacted, err := json.Marshal(value)
+	if err != nil {
+		return body
+	}
+	return redacted
+}
+
+func redactFields(value interface{}) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, field := range value {
+			if sensitiveFields[strings.ToLower(key)] {
+				value[key] = RedactedText
+				continue
+			}
+			redactFields(field)
+		}
+	case []interface{}:
+		for _, field := range value {
+			redactFields(field)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package titanium
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/atomosio/common"
+	"gopkg.in/yaml.v3"
+)
+
+// Name of the manifest describing the kernel in a kernel source directory.
+const KernelManifestFile = "titanium.yaml"
+
+var (
+	ErrManifestNoProject = errors.New("Kernel manifest does not name a project")
+	ErrManifestNoCommand = errors.New("Kernel manifest does not declare a command")
+	ErrKernelNoOutput    = errors.New("Kernel requires atleast one output-capable interface")
+)
+
+// Contents of a titanium.yaml file:
+//
+//	project: org/cleaner
+//	command: ./clean.sh
+//	image: default
+//	interfaces:
+//	  - name: in
+//	    description: Raw records
+//	    path: /data/in.csv
+//	    type: file
+//	    direction: in
+//	  - name: out
+//	    path: /data/out.csv
+//	    type: file
+//	    direction: out
+//	resources:
+//	  cpu: 2
+//	  memory: 4Gi
+//	  timeout: 1h
+type KernelManifest struct {
+	Project    string                    `yaml:"project"`
+	Command    string                    `yaml:"command"`
+	Image      string                    `yaml:"image,omitempty"`
+	Interfaces []KernelManifestInterface `yaml:"interfaces"`
+	Resources  *KernelManifestResources  `yaml:"resources,omitempty"`
+	Env        map[string]string         `yaml:"env,omitempty"`
+}
+
+type KernelManifestResources struct {
+	CPU     float64 `yaml:"cpu,omitempty"`
+	Memory  string  `yaml:"memory,omitempty"`
+	Disk    string  `yaml:"disk,omitempty"`
+	Timeout string  `yaml:"timeout,omitempty"`
+}
+
+type KernelManifestInterface struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description,omitempty"`
+	Path        string `yaml:"path,omitempty"`
+	Type        string `yaml:"type"`
+	Direction   string `yaml:"direction"`
+	Optional    bool   `yaml:"optional,omitempty"`
+	Kind        string `yaml:"kind,omitempty"`
+	Delivery    string `yaml:"delivery,omitempty"`
+}
+
+// Reads the titanium.yaml manifest of a kernel source directory.
+func LoadKernelManifest(dir string) (KernelManifest, error) {
+	var manifest KernelManifest
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, KernelManifestFile))
+	if err != nil {
+		return manifest, err
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err = decoder.Decode(&manifest)
+	if err != nil && err != io.EOF {
+		return manifest, fmt.Errorf("%s: %s", KernelManifestFile, err)
+	}
+
+	return manifest, nil
+}
+
+// Converts the manifest to a Kernel and validates it.
+func (manifest KernelManifest) Kernel() (Kernel, error) {
+	kernel := Kernel{
+		Command:    manifest.Command,
+		Image:      manifest.Image,
+		Interfaces: make([]KernelInterface, len(manifest.Interfaces)),
+		Env:        manifest.Env,
+	}
+
+	for index, minterface := range manifest.Interfaces {
+		kinterface := KernelInterface{
+			Name:        minterface.Name,
+			Description: minterface.Description,
+			Path:        minterface.Path,
+			Optional:    minterface.Optional,
+		}
+
+		err := kinterface.Type.UnmarshalText([]byte(minterface.Type))
+		if err == nil {
+			err = kinterface.Direction.UnmarshalText([]byte(minterface.Direction))
+		}
+		if err == nil && minterface.Kind != "" {
+			err = kinterface.Kind.UnmarshalText([]byte(minterface.Kind))
+		}
+		if err == nil && minterface.Delivery != "" {
+			err = kinterface.Delivery.UnmarshalText([]byte(minterface.Delivery))
+		}
+		if err != nil {
+			return kernel, fmt.Errorf("Interface %q: %s", minterface.Name, err)
+		}
+
+		kernel.Interfaces[index] = kinterface
+	}
+
+	if manifest.Resources != nil {
+		kernel.Resources = &Resources{
+			CPU: manifest.Resources.CPU,
+		}
+		var err error
+		if manifest.Resources.Memory != "" {
+			kernel.Resources.Memory, err = ParseByteSize(manifest.Resources.Memory)
+		}
+		if err == nil && manifest.Resources.Disk != "" {
+			kernel.Resources.Disk, err = ParseByteSize(manifest.Resources.Disk)
+		}
+		if err == nil && manifest.Resources.Timeout != "" {
+			err = kernel.Resources.Timeout.UnmarshalText([]byte(manifest.Resources.Timeout))
+		}
+		if err != nil {
+			return kernel, fmt.Errorf("Resources: %s", err)
+		}
+	}
+
+	if kernel.Command == "" {
+		return kernel, ErrManifestNoCommand
+	}
+
+	hasOutput := false
+	for _, kinterface := range kernel.Interfaces {
+		if kinterface.Direction == OutDirection || kinterface.Direction == InOutDirection {
+			hasOutput = true
+		}
+	}
+	if !hasOutput {
+		return kernel, ErrKernelNoOutput
+	}
+
+	return kernel, kernel.Validate()
+}
+
+// Packages the contents of dir as a gzipped tarball. Version control
+// directories are left out.
+func PackageKernelDir(dir string, w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		if info.IsDir() && (info.Name() == ".git" || info.Name() == ".hg") {
+			return filepath.SkipDir
+		}
+
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, err = os.Readlink(path)
+			if err != nil {
+				return err
+			}
+		} else if !info.IsDir() && !info.Mode().IsRegular() {
+			// Sockets, devices and the like can't be shipped
+			return nil
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		err = tw.WriteHeader(header)
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		_, err = io.Copy(tw, file)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Uploads a kernel from its source directory. The titanium.yaml manifest in
+// dir is validated, including the existence of the image it names, the
+// directory contents are uploaded to the project it names, and the project
+// kernel is updated to match the manifest.
+func (client *HttpClient) PushKernel(ctx context.Context, dir string) error {
+	manifest, err := LoadKernelManifest(dir)
+	if err != nil {
+		return err
+	}
+	if manifest.Project == "" {
+		return ErrManifestNoProject
+	}
+
+	kernel, err := manifest.Kernel()
+	if err != nil {
+		return err
+	}
+	err = client.ValidateKernelImage(ctx, kernel)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	err = PackageKernelDir(dir, &buf)
+	if err != nil {
+		return err
+	}
+
+	// Upload the sources before pointing the kernel at them
+	response := Response{}
+	addr := fmt.Sprintf("%s/%s/source", ProjectsEndpoint, manifest.Project)
+	err = client.DoRawMethodAndUnmarshalContext(ctx, "PUT", addr, "application/gzip", &buf, &response)
+	if err != nil {
+		return err
+	}
+	if response.Code != common.Success {
+		return errors.New(response.Description)
+	}
+
+	// The image was checked before uploading
+	return client.setProjectKernel(ctx, manifest.Project, kernel)
+}
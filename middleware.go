@@ -0,0 +1,86 @@
+package titanium
+
+import (
+	"net/http"
+)
+
+// Wraps the transport requests are sent through, to observe or alter
+// requests and responses: tracing, metrics, signing, header injection,
+// recording and the like.
+type Middleware func(next http.RoundTripper) http.RoundTripper
+
+// Adapts a function to http.RoundTripper.
+type RoundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// Adds middleware to the chain requests go through. The first middleware
+// added sees requests first and responses last.
+func (client *HttpClient) Use(middleware ...Middleware) *HttpClient {
+	client.middleware = append(client.middleware, middleware...)
+	client.buildHTTPClient()
+	return client
+}
+
+// Sends requests through httpClient, which controls timeouts, redirects and
+// cookies. Its transport ends up below the middleware chain.
+func (client *HttpClient) WithHTTPClient(httpClient *http.Client) *HttpClient {
+	client.client = httpClient
+	client.buildHTTPClient()
+	return client
+}
+
+// Sends requests through transport, for example an *http.Transport with
+// custom proxy or TLS settings.
+func (client *HttpClient) WithTransport(transport http.RoundTripper) *HttpClient {
+	httpClient := *client.client
+	httpClient.Transport = transport
+	client.client = &httpClient
+	client.buildHTTPClient()
+	return client
+}
+
+// Returns the http.Client requests are sent with.
+func (client *HttpClient) httpClient() *http.Client {
+	return client.sender
+}
+
+// Builds the http.Client requests are sent with, its transport wrapped by the
+// rate limiter and the middleware chain. Called whenever one of them changes.
+func (client *HttpClient) buildHTTPClient() {
+	if len(client.middleware) == 0 && client.limiter == nil {
+		client.sender = client.client
+		return
+	}
+
+	transport := client.client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	if client.limiter != nil {
+		transport = client.limiter.wrap(transport)
+	}
+	for index := len(client.middleware) - 1; index >= 0; index-- {
+		transport = client.middleware[index](transport)
+	}
+
+	httpClient := *client.client
+	httpClient.Transport = transport
+	client.sender = &httpClient
+}
+
+// Sets the given headers on every request.
+func HeaderMiddleware(header http.Header) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			// Requests must not be modified by round trippers
+			req = req.Clone(req.Context())
+			for name, values := range header {
+				req.Header[http.CanonicalHeaderKey(name)] = values
+			}
+			return next.RoundTrip(req)
+		})
+	}
+}
@@ -1,8 +1,10 @@
 package titanium
 
 import (
+	"context"
 	"errors"
 	"fmt"
+
 	"github.com/atomosio/common"
 )
 
@@ -33,6 +35,9 @@ func (client *HttpClient) CreateProject(projectName string, public bool) error {
 	return nil
 }
 
+// Deprecated: kept for existing callers. The client sends the same fields,
+// with Title, Description and Public left nil when not updated so that empty
+// values can be set.
 type UpdateProjectRequest struct {
 	Title         string                   `json:"title,omitempty"`
 	Description   string                   `json:"description,omitempty"`
@@ -42,9 +47,20 @@ type UpdateProjectRequest struct {
 	Type          string                   `json:"type"`
 }
 
-func (client *HttpClient) SetTitle(project, title string) {
-	request := UpdateProjectRequest{
-		Title: title,
+// Fields left nil are not updated, so that empty values can be set.
+type updateProjectRequest struct {
+	Title         *string               `json:"title,omitempty"`
+	Description   *string               `json:"description,omitempty"`
+	Public        *bool                 `json:"public,omitempty"`
+	Interfaces    []ProjectInterface    `json:"interfaces,omitempty"`
+	Configuration []ConfigurationEntity `json:"configuration,omitempty"`
+	Kernel        *Kernel               `json:"kernel,omitempty"`
+	Type          string                `json:"type"`
+}
+
+func (client *HttpClient) SetTitle(project, title string) error {
+	request := updateProjectRequest{
+		Title: &title,
 	}
 
 	//send request
@@ -52,18 +68,19 @@ func (client *HttpClient) SetTitle(project, title string) {
 	addr := fmt.Sprintf("%s/%s", ProjectsEndpoint, project)
 	err := client.DoMethodAndUnmarshal("PATCH", addr, &request, &response)
 	if err != nil {
-		//TODO Change panic to return error
-		panic(err)
+		return err
 	}
 
 	if response.Code != common.Success {
-		panic(response.Description)
+		return errors.New(response.Description)
 	}
+
+	return nil
 }
 
-func (client *HttpClient) SetDescription(project, description string) {
-	request := UpdateProjectRequest{
-		Description: description,
+func (client *HttpClient) SetDescription(project, description string) error {
+	request := updateProjectRequest{
+		Description: &description,
 	}
 
 	//send request
@@ -71,56 +88,248 @@ func (client *HttpClient) SetDescription(project, description string) {
 	addr := fmt.Sprintf("%s/%s", ProjectsEndpoint, project)
 	err := client.DoMethodAndUnmarshal("PATCH", addr, &request, &response)
 	if err != nil {
-		//TODO Change panic to return error
-		panic(err)
+		return err
 	}
 
 	if response.Code != common.Success {
-		panic(response.Description)
+		return errors.New(response.Description)
 	}
+
+	return nil
+}
+
+func (client *HttpClient) SetProjectSystem(project string, interfaces []ProjectInterface, entities []ConfigurationEntity) error {
+	return client.SetProjectSystemContext(context.Background(), project, interfaces, entities)
 }
 
-func (client *HttpClient) SetProjectSystem(project string, interfaces []ProjectInterface, entities []ConfigurationEntity) {
-	// Convert from ProjectInterface to OutProjectInterface
-	outInterfaces := ProjectInterfacesToOutProjectInterfaces(interfaces)
-	outConfigurations := ConfigurationEntitiesToOutConfigurationEntities(entities)
-	request := UpdateProjectRequest{
+// Same as SetProjectSystem, but the request is bound to ctx.
+func (client *HttpClient) SetProjectSystemContext(ctx context.Context, project string, interfaces []ProjectInterface, entities []ConfigurationEntity) error {
+	err := ValidateSystem(interfaces, entities)
+	if err != nil {
+		return err
+	}
+
+	request := updateProjectRequest{
 		Type:          ProjectTypeToString[ProjectSystemType],
-		Interfaces:    outInterfaces,
-		Configuration: outConfigurations,
+		Interfaces:    interfaces,
+		Configuration: entities,
 	}
 
 	//send request
 	response := Response{}
 	addr := fmt.Sprintf("%s/%s", ProjectsEndpoint, project)
-	err := client.DoMethodAndUnmarshal("PATCH", addr, &request, &response)
+	err = client.DoMethodAndUnmarshalContext(ctx, "PATCH", addr, &request, &response)
 	if err != nil {
-		//TODO Change panic to return error
-		panic(err)
+		return err
 	}
 
 	if response.Code != common.Success {
-		panic(response.Description)
+		return errors.New(response.Description)
+	}
+
+	return nil
+}
+
+func (client *HttpClient) SetProjectKernel(project string, kernel Kernel) error {
+	return client.SetProjectKernelContext(context.Background(), project, kernel)
+}
+
+// Same as SetProjectKernel, but the request is bound to ctx. Kernels naming an
+// unknown image are rejected before the project is modified.
+func (client *HttpClient) SetProjectKernelContext(ctx context.Context, project string, kernel Kernel) error {
+	err := kernel.Validate()
+	if err == nil {
+		err = client.ValidateKernelImage(ctx, kernel)
+	}
+	if err != nil {
+		return err
 	}
+	return client.setProjectKernel(ctx, project, kernel)
 }
 
-func (client *HttpClient) SetProjectKernel(project string, kernel Kernel) {
-	// Convert from ProjectInterface to OutProjectInterface
-	outKernel := KernelToOutKernel(kernel)
-	request := UpdateProjectRequest{
+// Sets the kernel of project, which must have been validated.
+func (client *HttpClient) setProjectKernel(ctx context.Context, project string, kernel Kernel) error {
+	request := updateProjectRequest{
 		Type:   ProjectTypeToString[ProjectKernelType],
-		Kernel: &outKernel,
+		Kernel: &kernel,
 	}
 
 	//send request
 	response := Response{}
 	addr := fmt.Sprintf("%s/%s", ProjectsEndpoint, project)
-	err := client.DoMethodAndUnmarshal("PATCH", addr, &request, &response)
+	err := client.DoMethodAndUnmarshalContext(ctx, "PATCH", addr, &request, &response)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	if response.Code != common.Success {
-		panic(response.Description)
+		return errors.New(response.Description)
+	}
+
+	return nil
+}
+
+// Definition of a project as stored by the server. Depending on Type, either
+// Interfaces and Configuration or Kernel are set.
+type ProjectDefinition struct {
+	Name          string                `json:"name"`
+	Version       string                `json:"version,omitempty"`
+	Title         string                `json:"title,omitempty"`
+	Description   string                `json:"description,omitempty"`
+	Public        bool                  `json:"public"`
+	Type          string                `json:"type"`
+	Interfaces    []ProjectInterface    `json:"interfaces,omitempty"`
+	Configuration []ConfigurationEntity `json:"configuration,omitempty"`
+	Kernel        *Kernel               `json:"kernel,omitempty"`
+}
+
+type GetProjectResponse struct {
+	Response
+	Project ProjectDefinition `json:"project"`
+}
+
+// Retreives the definition of a project and validates it. The project may be
+// pinned to a version or tag, as in 'name@version'.
+func (client *HttpClient) GetProjectDefinition(ctx context.Context, project string) (ProjectDefinition, error) {
+	var response GetProjectResponse
+
+	name, version, err := ParseProjectRef(project)
+	if err != nil {
+		return ProjectDefinition{}, err
+	}
+
+	// Get and unmarshal
+	addr := fmt.Sprintf("%s/%s", ProjectsEndpoint, name)
+	if version != "" {
+		addr = fmt.Sprintf("%s/%s/versions/%s", ProjectsEndpoint, name, version)
+	}
+	err = client.DoEmptyMethodAndUnmarshalContext(ctx, "GET", addr, &response)
+	if err != nil {
+		return ProjectDefinition{}, err
 	}
+
+	if response.Code != common.Success {
+		return ProjectDefinition{}, errors.New("Failed to get project information: " + response.Description)
+	}
+
+	definition := response.Project
+	if definition.Name == "" {
+		definition.Name = name
+	}
+	if definition.Version == "" {
+		definition.Version = version
+	}
+	return definition, definition.Validate()
+}
+
+// Uploads a definition, typically one retreived with GetProjectDefinition and
+// modified since. The name of the definition selects the project. Published
+// versions can not be modified, so the definition must not carry a version.
+func (client *HttpClient) SetProjectDefinition(ctx context.Context, definition ProjectDefinition) error {
+	err := definition.Validate()
+	if err != nil {
+		return err
+	}
+	if definition.Version != "" {
+		return ErrProjectVersionImmutable
+	}
+	if definition.Kernel != nil {
+		err = client.ValidateKernelImage(ctx, *definition.Kernel)
+		if err != nil {
+			return err
+		}
+	}
+
+	request := updateProjectRequest{
+		Title:         &definition.Title,
+		Description:   &definition.Description,
+		Public:        &definition.Public,
+		Type:          definition.Type,
+		Interfaces:    definition.Interfaces,
+		Configuration: definition.Configuration,
+		Kernel:        definition.Kernel,
+	}
+
+	//send request
+	response := Response{}
+	addr := fmt.Sprintf("%s/%s", ProjectsEndpoint, definition.Name)
+	err = client.DoMethodAndUnmarshalContext(ctx, "PATCH", addr, &request, &response)
+	if err != nil {
+		return err
+	}
+
+	if response.Code != common.Success {
+		return errors.New(response.Description)
+	}
+
+	return nil
+}
+
+// Checks the definition is consistent with its type.
+func (definition ProjectDefinition) Validate() error {
+	if definition.Name == "" {
+		return errors.New("Project definition without a name")
+	}
+
+	projectType, ok := ProjectTypeStrings[definition.Type]
+	if !ok {
+		return fmt.Errorf("Project %q has unknown type %q", definition.Name, definition.Type)
+	}
+
+	switch projectType {
+	case ProjectSystemType:
+		if definition.Kernel != nil {
+			return fmt.Errorf("System project %q can not have a kernel", definition.Name)
+		}
+		return ValidateSystem(definition.Interfaces, definition.Configuration)
+	case ProjectKernelType:
+		if len(definition.Interfaces) != 0 || len(definition.Configuration) != 0 {
+			return fmt.Errorf("Kernel project %q can not have system interfaces or configuration", definition.Name)
+		}
+		if definition.Kernel == nil {
+			return fmt.Errorf("Kernel project %q has no kernel", definition.Name)
+		}
+		return definition.Kernel.Validate()
+	}
+
+	return nil
+}
+
+// Checks the interfaces and entities of a system. Every interface needs a
+// unique name and every entity interface must be connected to an alias.
+// Interface types may be left undeclared, for a Resolver to infer.
+func ValidateSystem(interfaces []ProjectInterface, entities []ConfigurationEntity) error {
+	names := make(map[string]bool)
+	for _, pinterface := range interfaces {
+		err := pinterface.validateDeclared()
+		if err != nil {
+			return err
+		}
+		if names[pinterface.Name] {
+			return fmt.Errorf("Duplicate interface name %q", pinterface.Name)
+		}
+		if pinterface.Alias == "" {
+			return fmt.Errorf("Interface %q is not connected to an alias", pinterface.Name)
+		}
+		names[pinterface.Name] = true
+	}
+
+	entityNames := make(map[string]bool)
+	for _, entity := range entities {
+		if entityNames[entity.Name] {
+			return fmt.Errorf("Duplicate entity name %q", entity.Name)
+		}
+		if _, _, err := ParseProjectRef(entity.Kernel); err != nil {
+			return fmt.Errorf("Entity %q: %s", entity.Name, err)
+		}
+		entityNames[entity.Name] = true
+
+		for _, cinterface := range entity.Interfaces {
+			if cinterface.Alias == "" {
+				return fmt.Errorf("Interface %q of entity %q is not connected to an alias", cinterface.Name, entity.Name)
+			}
+		}
+	}
+
+	return nil
 }
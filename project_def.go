@@ -1,9 +1,12 @@
 package titanium
 
 import (
+	"encoding"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 )
 
 var _ = fmt.Print
@@ -12,6 +15,8 @@ var _ = os.Stderr
 
 type DirectionType int8
 type TypeType int8
+type ValueKind int8
+type DeliveryType int8
 
 const (
 	ProjectSystemType = iota
@@ -30,52 +35,70 @@ var ProjectTypeStrings = map[string]int8{
 
 type ProjectInterface struct {
 	// Name of the project interface
-	Name string
+	Name string `json:"name"`
 	// Human readable description of the interface
-	Description string
+	Description string `json:"description"`
 
 	// Alias used to connect the interface to an internal node
-	Alias     string
-	Type      TypeType
-	Direction DirectionType
-	Optional  bool
+	Alias     string        `json:"alias"`
+	Type      TypeType      `json:"type"`
+	Direction DirectionType `json:"direction"`
+	Optional  bool          `json:"optional"`
+
+	// Kind of value held by parameter and secret interfaces
+	Kind ValueKind `json:"kind,omitempty"`
 }
 
 type ConfigurationEntity struct {
 	// Name of the entity
-	Name string
+	Name string `json:"name"`
 	// Long description of the entity
-	Description string
+	Description string `json:"description"`
 
-	Kernel     string
-	Interfaces []ConfigurationEntityInterface
+	// Project run by the entity, either a kernel or another system. It may be
+	// pinned to a version or tag, as in 'name@version'.
+	Kernel     string                         `json:"kernel"`
+	Interfaces []ConfigurationEntityInterface `json:"interfaces"`
 }
 
 type ConfigurationEntityInterface struct {
-	Name  string
-	Alias string
+	Name  string `json:"name"`
+	Alias string `json:"alias"`
 }
 
 type Kernel struct {
 	// File to run when starting kernel. When a command is not empty, the project
 	// kernel is configured.
-	Command string
-	// If image is empty, it defaults to 'default'.
-	Image string
+	Command string `json:"command"`
+	// If image is empty, it defaults to 'default'. It may be pinned to a
+	// digest, as in 'name@sha256:...'.
+	Image string `json:"image"`
 
 	// If Command is not empty, there must be atleast one output-capable interface
 	// No two interface can have the same Path or Name.
-	Interfaces []KernelInterface
+	Interfaces []KernelInterface `json:"interfaces"`
+
+	// Resources of each instance, clusters may override them
+	Resources *Resources `json:"resources,omitempty"`
+
+	// Environment of the command. Use SecretRef for sensitive values.
+	Env map[string]string `json:"env,omitempty"`
 }
 
 type KernelInterface struct {
-	Name        string
-	Description string
+	Name        string `json:"name"`
+	Description string `json:"description"`
+
+	Path      string        `json:"path"`
+	Type      TypeType      `json:"type"`
+	Direction DirectionType `json:"direction"`
+	Optional  bool          `json:"optional"`
 
-	Path      string
-	Type      TypeType
-	Direction DirectionType
-	Optional  bool
+	// Kind of value held by parameter and secret interfaces
+	Kind ValueKind `json:"kind,omitempty"`
+	// How parameter and secret values reach the kernel. Path holds the name of
+	// the environment variable or command line flag.
+	Delivery DeliveryType `json:"delivery,omitempty"`
 }
 
 // Interface Directions
@@ -101,19 +124,206 @@ var NodeDirectionToStrings = []string{
 
 // Interface Types
 const (
-	InvalidType = iota
-	FileType
+	InvalidType   = iota
+	FileType      // A single file
+	DirectoryType // A directory tree, staged as a tarball
+	ParameterType // A scalar value passed as an environment variable or argument
+	SecretType    // Like a parameter, but the value is never displayed
+	StreamType    // A pipe between concurrently running kernels
 )
 
 var NodeTypeStrings = map[string]TypeType{
-	"invalid": InvalidType,
-	"file":    FileType,
+	"invalid":   InvalidType,
+	"file":      FileType,
+	"directory": DirectoryType,
+	"parameter": ParameterType,
+	"secret":    SecretType,
+	"stream":    StreamType,
 }
 var NodeTypeToStrings = []string{
 	"invalid",
 	"file",
+	"directory",
+	"parameter",
+	"secret",
+	"stream",
+}
+
+// Directions each interface type can be used in
+var NodeTypeDirections = map[TypeType][]DirectionType{
+	FileType:      {InDirection, OutDirection, InOutDirection},
+	DirectoryType: {InDirection, OutDirection, InOutDirection},
+	ParameterType: {InDirection},
+	SecretType:    {InDirection},
+	StreamType:    {InDirection, OutDirection},
+}
+
+// Value kinds of parameter and secret interfaces
+const (
+	StringKind = iota
+	NumberKind
+	BoolKind
+)
+
+var ValueKindStrings = map[string]ValueKind{
+	"string": StringKind,
+	"number": NumberKind,
+	"bool":   BoolKind,
+}
+var ValueKindToStrings = []string{
+	"string",
+	"number",
+	"bool",
+}
+
+// Delivery of parameter and secret values to kernels
+const (
+	EnvDelivery = iota
+	ArgDelivery
+)
+
+var DeliveryStrings = map[string]DeliveryType{
+	"env": EnvDelivery,
+	"arg": ArgDelivery,
+}
+var DeliveryToStrings = []string{
+	"env",
+	"arg",
 }
 
+var (
+	ErrInvalidNodeType      = errors.New("Invalid interface type")
+	ErrInvalidNodeDirection = errors.New("Invalid interface direction")
+	ErrInvalidValueKind     = errors.New("Invalid interface value kind")
+	ErrInvalidDelivery      = errors.New("Invalid interface delivery")
+)
+
+// Checks that an interface of type nodeType may be used in direction.
+func ValidateNodeDirection(nodeType TypeType, direction DirectionType) error {
+	directions, ok := NodeTypeDirections[nodeType]
+	if !ok {
+		return ErrInvalidNodeType
+	}
+
+	for _, allowed := range directions {
+		if allowed == direction {
+			return nil
+		}
+	}
+
+	if direction <= InvalidDirection || int(direction) >= len(NodeDirectionToStrings) {
+		return ErrInvalidNodeDirection
+	}
+	return fmt.Errorf("%s interfaces can not be used in direction %s", nodeType, direction)
+}
+
+// Checks that value is acceptable for a parameter or secret of the given kind.
+func ValidateValue(kind ValueKind, value string) error {
+	var err error
+	switch kind {
+	case StringKind:
+	case NumberKind:
+		_, err = strconv.ParseFloat(value, 64)
+	case BoolKind:
+		_, err = strconv.ParseBool(value)
+	default:
+		return ErrInvalidValueKind
+	}
+
+	if err != nil {
+		return fmt.Errorf("%q is not a valid %s", value, kind)
+	}
+	return nil
+}
+
+func validateKind(nodeType TypeType, kind ValueKind) error {
+	if kind < StringKind || int(kind) >= len(ValueKindToStrings) {
+		return ErrInvalidValueKind
+	}
+	if kind != StringKind && nodeType != ParameterType && nodeType != SecretType {
+		return fmt.Errorf("%s interfaces do not hold %s values", nodeType, kind)
+	}
+	return nil
+}
+
+// Checks the interface, whose type must be known. Interfaces of a resolved
+// system have their type inferred when it was left undeclared.
+func (pinterface ProjectInterface) Validate() error {
+	err := ValidateNodeDirection(pinterface.Type, pinterface.Direction)
+	if err == nil {
+		err = validateKind(pinterface.Type, pinterface.Kind)
+	}
+	if err != nil {
+		return fmt.Errorf("Interface %q: %s", pinterface.Name, err)
+	}
+	return nil
+}
+
+// Checks the interface as declared in a system definition, which may leave its
+// type to be inferred from the kernels it connects to.
+func (pinterface ProjectInterface) validateDeclared() error {
+	if pinterface.Type != InvalidType {
+		return pinterface.Validate()
+	}
+	if pinterface.Direction <= InvalidDirection || int(pinterface.Direction) >= len(NodeDirectionToStrings) {
+		return fmt.Errorf("Interface %q: %s", pinterface.Name, ErrInvalidNodeDirection)
+	}
+	return nil
+}
+
+func (kinterface KernelInterface) Validate() error {
+	err := ValidateNodeDirection(kinterface.Type, kinterface.Direction)
+	if err == nil {
+		err = validateKind(kinterface.Type, kinterface.Kind)
+	}
+	if err == nil && (kinterface.Delivery < EnvDelivery || int(kinterface.Delivery) >= len(DeliveryToStrings)) {
+		err = ErrInvalidDelivery
+	}
+	if err != nil {
+		return fmt.Errorf("Interface %q: %s", kinterface.Name, err)
+	}
+	return nil
+}
+
+// Checks the image reference and interfaces of the kernel. No two interfaces
+// can share a Name or Path.
+func (kernel Kernel) Validate() error {
+	if kernel.Image != "" {
+		if _, _, err := ParseImageRef(kernel.Image); err != nil {
+			return err
+		}
+	}
+	if kernel.Resources != nil {
+		if err := kernel.Resources.Validate(); err != nil {
+			return err
+		}
+	}
+
+	names := make(map[string]bool)
+	paths := make(map[string]bool)
+	for _, kinterface := range kernel.Interfaces {
+		err := kinterface.Validate()
+		if err != nil {
+			return err
+		}
+
+		if names[kinterface.Name] {
+			return fmt.Errorf("Duplicate interface name %q", kinterface.Name)
+		}
+		if kinterface.Path != "" && paths[kinterface.Path] {
+			return fmt.Errorf("Duplicate interface path %q", kinterface.Path)
+		}
+		names[kinterface.Name] = true
+		paths[kinterface.Path] = true
+	}
+
+	return nil
+}
+
+// The Out* types predate the definition types serializing directly. They are
+// kept, string typed, for existing callers.
+
+// Deprecated: ProjectInterface serializes directly.
 type OutProjectInterface struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
@@ -121,8 +331,10 @@ type OutProjectInterface struct {
 	Type        string `json:"type"`
 	Direction   string `json:"direction"`
 	Optional    bool   `json:"optional"`
+	Kind        string `json:"kind,omitempty"`
 }
 
+// Deprecated: ConfigurationEntity serializes directly.
 type OutConfigurationEntity struct {
 	Name        string                            `json:"name"`
 	Description string                            `json:"description"`
@@ -130,17 +342,22 @@ type OutConfigurationEntity struct {
 	Interfaces  []OutConfigurationEntityInterface `json:"interfaces"`
 }
 
+// Deprecated: ConfigurationEntityInterface serializes directly.
 type OutConfigurationEntityInterface struct {
 	Name  string `json:"name"`
 	Alias string `json:"alias"`
 }
 
+// Deprecated: Kernel serializes directly.
 type OutKernel struct {
 	Command    string               `json:"command"`
 	Image      string               `json:"image"`
 	Interfaces []OutKernelInterface `json:"interfaces"`
+	Resources  *Resources           `json:"resources,omitempty"`
+	Env        map[string]string    `json:"env,omitempty"`
 }
 
+// Deprecated: KernelInterface serializes directly.
 type OutKernelInterface struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
@@ -149,8 +366,11 @@ type OutKernelInterface struct {
 	Type      string `json:"type"`
 	Direction string `json:"direction"`
 	Optional  bool   `json:"optional"`
+	Kind      string `json:"kind,omitempty"`
+	Delivery  string `json:"delivery,omitempty"`
 }
 
+// Deprecated: ProjectInterface serializes directly.
 func ProjectInterfacesToOutProjectInterfaces(interfaces []ProjectInterface) []OutProjectInterface {
 	output := make([]OutProjectInterface, len(interfaces))
 	for index, pinterface := range interfaces {
@@ -158,15 +378,19 @@ func ProjectInterfacesToOutProjectInterfaces(interfaces []ProjectInterface) []Ou
 			Name:        pinterface.Name,
 			Description: pinterface.Description,
 			Alias:       pinterface.Alias,
-			Type:        NodeTypeToStrings[pinterface.Type],
-			Direction:   NodeDirectionToStrings[pinterface.Direction],
+			Type:        outText(pinterface.Type),
+			Direction:   outText(pinterface.Direction),
 			Optional:    pinterface.Optional,
 		}
+		if pinterface.Type == ParameterType || pinterface.Type == SecretType {
+			output[index].Kind = outText(pinterface.Kind)
+		}
 	}
 
 	return output
 }
 
+// Deprecated: ConfigurationEntity serializes directly.
 func ConfigurationEntitiesToOutConfigurationEntities(entities []ConfigurationEntity) []OutConfigurationEntity {
 	output := make([]OutConfigurationEntity, len(entities))
 	for eindex, centities := range entities {
@@ -189,11 +413,14 @@ func ConfigurationEntitiesToOutConfigurationEntities(entities []ConfigurationEnt
 	return output
 }
 
+// Deprecated: Kernel serializes directly.
 func KernelToOutKernel(kernel Kernel) OutKernel {
 	output := OutKernel{
 		Command:    kernel.Command,
 		Image:      kernel.Image,
 		Interfaces: make([]OutKernelInterface, len(kernel.Interfaces)),
+		Resources:  kernel.Resources,
+		Env:        kernel.Env,
 	}
 
 	for index, kinterface := range kernel.Interfaces {
@@ -201,11 +428,25 @@ func KernelToOutKernel(kernel Kernel) OutKernel {
 			Name:        kinterface.Name,
 			Description: kinterface.Description,
 			Path:        kinterface.Path,
-			Type:        NodeTypeToStrings[kinterface.Type],
-			Direction:   NodeDirectionToStrings[kinterface.Direction],
+			Type:        outText(kinterface.Type),
+			Direction:   outText(kinterface.Direction),
 			Optional:    kinterface.Optional,
 		}
+		if kinterface.Type == ParameterType || kinterface.Type == SecretType {
+			output.Interfaces[index].Kind = outText(kinterface.Kind)
+			output.Interfaces[index].Delivery = outText(kinterface.Delivery)
+		}
 	}
 
 	return output
 }
+
+// Returns the text of an enumeration value for the Out* types, empty if the
+// value is unknown so that the server rejects it.
+func outText(value encoding.TextMarshaler) string {
+	text, err := value.MarshalText()
+	if err != nil {
+		return ""
+	}
+	return string(text)
+}
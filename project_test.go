@@ -0,0 +1,304 @@
+package titanium_test
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/atomosio/titanium-go"
+	"github.com/atomosio/titanium-go/titaniumtest"
+)
+
+func kernelDefinition() titanium.ProjectDefinition {
+	return titanium.ProjectDefinition{
+		Name:        "wordcount",
+		Title:       "Word count",
+		Description: "Counts the words of a text",
+		Public:      true,
+		Type:        titanium.ProjectTypeToString[titanium.ProjectKernelType],
+		Kernel: &titanium.Kernel{
+			Command: "./count.sh",
+			Image:   "alpine",
+			Interfaces: []titanium.KernelInterface{
+				{
+					Name:        "text",
+					Description: "Text to count",
+					Path:        "/in/text",
+					Type:        titanium.FileType,
+					Direction:   titanium.InDirection,
+				},
+				{
+					Name:      "threshold",
+					Path:      "THRESHOLD",
+					Type:      titanium.ParameterType,
+					Direction: titanium.InDirection,
+					Optional:  true,
+					Kind:      titanium.NumberKind,
+					Delivery:  titanium.EnvDelivery,
+				},
+				{
+					Name:      "count",
+					Path:      "/out/count",
+					Type:      titanium.FileType,
+					Direction: titanium.OutDirection,
+				},
+			},
+			Resources: &titanium.Resources{
+				CPU:    2,
+				Memory: 512 << 20,
+			},
+			Env: map[string]string{
+				"LANG": "C",
+			},
+		},
+	}
+}
+
+func systemDefinition() titanium.ProjectDefinition {
+	return titanium.ProjectDefinition{
+		Name:        "pipeline",
+		Title:       "Pipeline",
+		Description: "Counts words twice",
+		Type:        titanium.ProjectTypeToString[titanium.ProjectSystemType],
+		Interfaces: []titanium.ProjectInterface{
+			{
+				Name:      "text",
+				Alias:     "input",
+				Type:      titanium.FileType,
+				Direction: titanium.InDirection,
+			},
+			{
+				Name:      "count",
+				Alias:     "result",
+				Type:      titanium.FileType,
+				Direction: titanium.OutDirection,
+			},
+		},
+		Configuration: []titanium.ConfigurationEntity{
+			{
+				Name:   "counter",
+				Kernel: "wordcount@1.0.0",
+				Interfaces: []titanium.ConfigurationEntityInterface{
+					{Name: "text", Alias: "input"},
+					{Name: "count", Alias: "result"},
+				},
+			},
+		},
+	}
+}
+
+// Fetches the definition stored on the server, applies modify, uploads it and
+// checks the server then holds exactly the modified definition.
+func roundTrip(t *testing.T, stored titanium.ProjectDefinition, modify func(*titanium.ProjectDefinition)) {
+	t.Helper()
+
+	server := titaniumtest.NewServer()
+	defer server.Close()
+	server.PutProject(stored)
+	server.PutImage(titanium.Image{Name: "alpine"})
+	client := server.Client("token")
+	ctx := context.Background()
+
+	definition, err := client.GetProjectDefinition(ctx, stored.Name)
+	if err != nil {
+		t.Fatalf("GetProjectDefinition: %s", err)
+	}
+	if !reflect.DeepEqual(definition, stored) {
+		t.Fatalf("fetched definition differs from the stored one\ngot:  %+v\nwant: %+v", definition, stored)
+	}
+
+	modify(&definition)
+	err = client.SetProjectDefinition(ctx, definition)
+	if err != nil {
+		t.Fatalf("SetProjectDefinition: %s", err)
+	}
+
+	uploaded, ok := server.GetProject(stored.Name)
+	if !ok {
+		t.Fatalf("project %q is gone", stored.Name)
+	}
+	if !reflect.DeepEqual(uploaded, definition) {
+		t.Errorf("uploaded definition differs from the modified one\ngot:  %+v\nwant: %+v", uploaded, definition)
+	}
+}
+
+func TestProjectDefinitionRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		stored titanium.ProjectDefinition
+		modify func(*titanium.ProjectDefinition)
+	}{
+		{
+			name:   "kernel unmodified",
+			stored: kernelDefinition(),
+			modify: func(*titanium.ProjectDefinition) {},
+		},
+		{
+			name:   "system unmodified",
+			stored: systemDefinition(),
+			modify: func(*titanium.ProjectDefinition) {},
+		},
+		{
+			name:   "kernel command",
+			stored: kernelDefinition(),
+			modify: func(definition *titanium.ProjectDefinition) {
+				definition.Kernel.Command = "./count.py"
+			},
+		},
+		{
+			name:   "system entity added",
+			stored: systemDefinition(),
+			modify: func(definition *titanium.ProjectDefinition) {
+				definition.Configuration = append(definition.Configuration, titanium.ConfigurationEntity{
+					Name:   "recounter",
+					Kernel: "wordcount",
+					Interfaces: []titanium.ConfigurationEntityInterface{
+						{Name: "text", Alias: "input"},
+					},
+				})
+			},
+		},
+		{
+			name:   "title and description cleared",
+			stored: kernelDefinition(),
+			modify: func(definition *titanium.ProjectDefinition) {
+				definition.Title = ""
+				definition.Description = ""
+			},
+		},
+		{
+			name:   "made private",
+			stored: kernelDefinition(),
+			modify: func(definition *titanium.ProjectDefinition) {
+				definition.Public = false
+			},
+		},
+		{
+			name:   "made public",
+			stored: systemDefinition(),
+			modify: func(definition *titanium.ProjectDefinition) {
+				definition.Public = true
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			roundTrip(t, test.stored, test.modify)
+		})
+	}
+}
+
+func TestSetProjectDefinitionRejectsVersion(t *testing.T) {
+	server := titaniumtest.NewServer()
+	defer server.Close()
+	server.PutProject(kernelDefinition())
+	client := server.Client("token")
+
+	definition := kernelDefinition()
+	definition.Version = "1.0.0"
+	err := client.SetProjectDefinition(context.Background(), definition)
+	if err != titanium.ErrProjectVersionImmutable {
+		t.Errorf("got error %v, want %v", err, titanium.ErrProjectVersionImmutable)
+	}
+}
+
+func TestSetKernelRejectsUnknownImage(t *testing.T) {
+	tests := []struct {
+		name string
+		set  func(*titanium.HttpClient, titanium.ProjectDefinition) error
+	}{
+		{
+			name: "SetProjectKernelContext",
+			set: func(client *titanium.HttpClient, definition titanium.ProjectDefinition) error {
+				return client.SetProjectKernelContext(context.Background(), definition.Name, *definition.Kernel)
+			},
+		},
+		{
+			name: "SetProjectDefinition",
+			set: func(client *titanium.HttpClient, definition titanium.ProjectDefinition) error {
+				return client.SetProjectDefinition(context.Background(), definition)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := titaniumtest.NewServer()
+			defer server.Close()
+			server.PutProject(kernelDefinition())
+			client := server.Client("token")
+
+			definition := kernelDefinition()
+			definition.Kernel.Image = "missing"
+			err := test.set(client, definition)
+			if err == nil {
+				t.Fatal("kernel with an unknown image was accepted")
+			}
+
+			stored, _ := server.GetProject(definition.Name)
+			if stored.Kernel.Image != kernelDefinition().Kernel.Image {
+				t.Errorf("project image changed to %q", stored.Kernel.Image)
+			}
+		})
+	}
+}
+
+func TestKernelToOutKernelKeepsEveryField(t *testing.T) {
+	kernel := *kernelDefinition().Kernel
+
+	data, err := json.Marshal(titanium.KernelToOutKernel(kernel))
+	if err != nil {
+		t.Fatalf("Marshal: %s", err)
+	}
+	var decoded titanium.Kernel
+	err = json.Unmarshal(data, &decoded)
+	if err != nil {
+		t.Fatalf("Unmarshal: %s", err)
+	}
+	if !reflect.DeepEqual(decoded, kernel) {
+		t.Errorf("kernel changed going through OutKernel\ngot:  %+v\nwant: %+v", decoded, kernel)
+	}
+}
+
+func TestOutConvertersTolerateUnknownValues(t *testing.T) {
+	kernel := titanium.Kernel{
+		Interfaces: []titanium.KernelInterface{
+			{Name: "odd", Type: titanium.ParameterType, Direction: 42, Kind: 42, Delivery: 42},
+		},
+	}
+	output := titanium.KernelToOutKernel(kernel)
+	if got := output.Interfaces[0]; got.Direction != "" || got.Kind != "" || got.Delivery != "" {
+		t.Errorf("unknown values converted to %+v", got)
+	}
+
+	interfaces := titanium.ProjectInterfacesToOutProjectInterfaces([]titanium.ProjectInterface{
+		{Name: "odd", Type: 42, Direction: titanium.InDirection},
+	})
+	if interfaces[0].Type != "" {
+		t.Errorf("unknown type converted to %q", interfaces[0].Type)
+	}
+}
+
+func TestSetTitleAndDescription(t *testing.T) {
+	server := titaniumtest.NewServer()
+	defer server.Close()
+	server.PutProject(kernelDefinition())
+	client := server.Client("token")
+
+	if err := client.SetTitle("wordcount", ""); err != nil {
+		t.Fatalf("SetTitle: %s", err)
+	}
+	if err := client.SetDescription("wordcount", "Counts words"); err != nil {
+		t.Fatalf("SetDescription: %s", err)
+	}
+	stored, _ := server.GetProject("wordcount")
+	if stored.Title != "" || stored.Description != "Counts words" {
+		t.Errorf("got title %q and description %q", stored.Title, stored.Description)
+	}
+
+	if err := client.SetTitle("missing", "Title"); err == nil {
+		t.Error("SetTitle of a missing project succeeded")
+	}
+}
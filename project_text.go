@@ -0,0 +1,100 @@
+package titanium
+
+import (
+	"fmt"
+)
+
+// Text encoding of the interface enumerations, used when definitions are
+// marshalled to and from JSON.
+
+func (direction DirectionType) String() string {
+	if direction < 0 || int(direction) >= len(NodeDirectionToStrings) {
+		return fmt.Sprintf("DirectionType(%d)", direction)
+	}
+	return NodeDirectionToStrings[direction]
+}
+
+func (direction DirectionType) MarshalText() ([]byte, error) {
+	if direction < 0 || int(direction) >= len(NodeDirectionToStrings) {
+		return nil, fmt.Errorf("Unknown interface direction %d", direction)
+	}
+	return []byte(NodeDirectionToStrings[direction]), nil
+}
+
+func (direction *DirectionType) UnmarshalText(text []byte) error {
+	value, ok := NodeDirectionStrings[string(text)]
+	if !ok {
+		return fmt.Errorf("Unknown interface direction %q", text)
+	}
+	*direction = value
+	return nil
+}
+
+func (nodeType TypeType) String() string {
+	if nodeType < 0 || int(nodeType) >= len(NodeTypeToStrings) {
+		return fmt.Sprintf("TypeType(%d)", nodeType)
+	}
+	return NodeTypeToStrings[nodeType]
+}
+
+func (nodeType TypeType) MarshalText() ([]byte, error) {
+	if nodeType < 0 || int(nodeType) >= len(NodeTypeToStrings) {
+		return nil, fmt.Errorf("Unknown interface type %d", nodeType)
+	}
+	return []byte(NodeTypeToStrings[nodeType]), nil
+}
+
+func (nodeType *TypeType) UnmarshalText(text []byte) error {
+	value, ok := NodeTypeStrings[string(text)]
+	if !ok {
+		return fmt.Errorf("Unknown interface type %q", text)
+	}
+	*nodeType = value
+	return nil
+}
+
+func (kind ValueKind) String() string {
+	if kind < 0 || int(kind) >= len(ValueKindToStrings) {
+		return fmt.Sprintf("ValueKind(%d)", kind)
+	}
+	return ValueKindToStrings[kind]
+}
+
+func (kind ValueKind) MarshalText() ([]byte, error) {
+	if kind < 0 || int(kind) >= len(ValueKindToStrings) {
+		return nil, fmt.Errorf("Unknown interface value kind %d", kind)
+	}
+	return []byte(ValueKindToStrings[kind]), nil
+}
+
+func (kind *ValueKind) UnmarshalText(text []byte) error {
+	value, ok := ValueKindStrings[string(text)]
+	if !ok {
+		return fmt.Errorf("Unknown interface value kind %q", text)
+	}
+	*kind = value
+	return nil
+}
+
+func (delivery DeliveryType) String() string {
+	if delivery < 0 || int(delivery) >= len(DeliveryToStrings) {
+		return fmt.Sprintf("DeliveryType(%d)", delivery)
+	}
+	return DeliveryToStrings[delivery]
+}
+
+func (delivery DeliveryType) MarshalText() ([]byte, error) {
+	if delivery < 0 || int(delivery) >= len(DeliveryToStrings) {
+		return nil, fmt.Errorf("Unknown interface delivery %d", delivery)
+	}
+	return []byte(DeliveryToStrings[delivery]), nil
+}
+
+func (delivery *DeliveryType) UnmarshalText(text []byte) error {
+	value, ok := DeliveryStrings[string(text)]
+	if !ok {
+		return fmt.Errorf("Unknown interface delivery %q", text)
+	}
+	*delivery = value
+	return nil
+}
@@ -0,0 +1,298 @@
+package titanium
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limits the requests sent to the API, see WithLimit.
+type Limit struct {
+	// Requests per second, zero means unlimited
+	Rate float64
+	// Requests that may be sent at once after a quiet period, defaults to 1
+	Burst int
+	// Requests awaiting their response at once, zero means unlimited. A
+	// request stays in flight until its response body is closed.
+	MaxInFlight int
+}
+
+// Number of times a request answered with 429 Too Many Requests is sent again
+// by a rate limited client, waiting as told by the Retry-After header.
+var RateLimitRetries = 3
+
+// Longest wait honoured from a Retry-After header, and the wait used when the
+// header is missing.
+var (
+	MaxRetryAfter     = time.Minute
+	DefaultRetryAfter = time.Second
+)
+
+// Applies limit to every request, except those to endpoints given their own
+// limit with WithEndpointLimit.
+//
+// When the server answers 429 Too Many Requests, requests to the endpoint are
+// held back for the duration given by Retry-After and its rate is halved,
+// recovering gradually as requests succeed. Requests whose body can be sent
+// again are retried up to RateLimitRetries times.
+func (client *HttpClient) WithLimit(limit Limit) *HttpClient {
+	client.limits().setDefault(limit)
+	return client
+}
+
+// Applies limit to requests to endpoint, one of the *Endpoint constants such
+// as InstancesEndpoint, instead of the limit given to WithLimit.
+func (client *HttpClient) WithEndpointLimit(endpoint string, limit Limit) *HttpClient {
+	client.limits().set(endpoint, limit)
+	return client
+}
+
+func (client *HttpClient) limits() *limiter {
+	if client.limiter == nil {
+		client.limiter = &limiter{
+			path:    client.path,
+			buckets: make(map[string]*bucket),
+		}
+		client.buildHTTPClient()
+	}
+	return client.limiter
+}
+
+type limiter struct {
+	// Base path of the API, stripped to find the endpoint of a request
+	path string
+
+	mutex    sync.Mutex
+	fallback *bucket
+	buckets  map[string]*bucket
+}
+
+func (l *limiter) setDefault(limit Limit) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.fallback = newBucket(limit)
+}
+
+func (l *limiter) set(endpoint string, limit Limit) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.buckets[endpoint] = newBucket(limit)
+}
+
+// Returns the bucket limiting requests to path, nil if unlimited.
+func (l *limiter) bucket(path string) *bucket {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	path = strings.TrimPrefix(strings.TrimPrefix(path, l.path), "/")
+	if index := strings.Index(path, "/"); index >= 0 {
+		if bucket, ok := l.buckets[path[:index+1]]; ok {
+			return bucket
+		}
+	}
+	return l.fallback
+}
+
+func (l *limiter) wrap(next http.RoundTripper) http.RoundTripper {
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		bucket := l.bucket(req.URL.Path)
+		if bucket == nil {
+			return next.RoundTrip(req)
+		}
+		return bucket.roundTrip(next, req)
+	})
+}
+
+// Token bucket, with a semaphore capping the requests in flight.
+type bucket struct {
+	limit    Limit
+	inFlight chan struct{}
+
+	mutex  sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+	// Requests are held back until then after a 429
+	until time.Time
+}
+
+func newBucket(limit Limit) *bucket {
+	if limit.Burst < 1 {
+		limit.Burst = 1
+	}
+
+	b := &bucket{
+		limit:  limit,
+		rate:   limit.Rate,
+		tokens: float64(limit.Burst),
+		last:   time.Now(),
+	}
+	if limit.MaxInFlight > 0 {
+		b.inFlight = make(chan struct{}, limit.MaxInFlight)
+	}
+	return b
+}
+
+func (b *bucket) roundTrip(next http.RoundTripper, req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(ctx)
+			req.Body = body
+		}
+
+		err := b.acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := next.RoundTrip(req)
+		if err != nil {
+			b.release()
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusTooManyRequests {
+			b.succeeded()
+			resp.Body = &releasingBody{ReadCloser: resp.Body, release: b.release}
+			return resp, nil
+		}
+
+		b.throttled(retryAfter(resp.Header.Get("Retry-After")))
+		if attempt >= RateLimitRetries || (req.Body != nil && req.GetBody == nil) {
+			resp.Body = &releasingBody{ReadCloser: resp.Body, release: b.release}
+			return resp, nil
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		b.release()
+	}
+}
+
+// Waits for a slot in flight and a token.
+func (b *bucket) acquire(ctx context.Context) error {
+	if b.inFlight != nil {
+		select {
+		case b.inFlight <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	for {
+		wait := b.take()
+		if wait <= 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			b.release()
+			return ctx.Err()
+		}
+	}
+}
+
+// Takes a token, returns how long to wait before trying again if there is
+// none.
+func (b *bucket) take() time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := time.Now()
+	if now.Before(b.until) {
+		return b.until.Sub(now)
+	}
+	if b.rate <= 0 {
+		return 0
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if burst := float64(b.limit.Burst); b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *bucket) release() {
+	if b.inFlight != nil {
+		<-b.inFlight
+	}
+}
+
+// Holds requests back for wait and halves the rate.
+func (b *bucket) throttled(wait time.Duration) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if until := time.Now().Add(wait); until.After(b.until) {
+		b.until = until
+	}
+	if b.rate > 0 {
+		b.rate /= 2
+		b.tokens = 0
+	}
+}
+
+// Recovers a twentieth of the configured rate.
+func (b *bucket) succeeded() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.rate < b.limit.Rate {
+		b.rate += b.limit.Rate / 20
+		if b.rate > b.limit.Rate {
+			b.rate = b.limit.Rate
+		}
+	}
+}
+
+// Parses a Retry-After header, either in seconds or an HTTP date.
+func retryAfter(value string) time.Duration {
+	wait := DefaultRetryAfter
+	if seconds, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+		wait = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		wait = time.Until(date)
+	}
+
+	if wait < 0 {
+		wait = 0
+	}
+	if wait > MaxRetryAfter {
+		wait = MaxRetryAfter
+	}
+	return wait
+}
+
+// Releases the in flight slot of a request once its body is closed.
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (body *releasingBody) Close() error {
+	err := body.ReadCloser.Close()
+	body.once.Do(body.release)
+	return err
+}
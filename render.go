@@ -0,0 +1,261 @@
+package titanium
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Output formats of RenderSystem
+const (
+	DotFormat     = "dot"
+	MermaidFormat = "mermaid"
+)
+
+// A node of the rendered graph
+type renderNode struct {
+	id    string
+	label string
+	shape string
+}
+
+// A connection between a node and the wire (alias) it reads or writes
+type renderEdge struct {
+	from, to string
+	label    string
+	dashed   bool
+	directed bool
+}
+
+type renderGroup struct {
+	kernel string
+	nodes  []renderNode
+}
+
+type renderGraph struct {
+	name   string
+	nodes  []renderNode
+	groups []*renderGroup
+	wires  []renderNode
+	edges  []renderEdge
+}
+
+// Renders a system project as a graph in the given format, DotFormat or
+// MermaidFormat. Entities are grouped by the kernel they run and every alias
+// is drawn as a small node connecting its readers and writers. Without kernel
+// information the direction of entity interfaces is unknown, so those edges
+// are drawn undirected, and only labelled with a type when their alias is
+// bound to a system interface; use RenderSystemWithKernels to resolve them.
+func RenderSystem(definition ProjectDefinition, format string) (string, error) {
+	return RenderSystemWithKernels(definition, format, nil)
+}
+
+// Same as RenderSystem, but entity interface types, directions and optionality
+// are taken from the kernels returned by lookup.
+func RenderSystemWithKernels(definition ProjectDefinition, format string, lookup KernelLookup) (string, error) {
+	if definition.Type != ProjectTypeToString[ProjectSystemType] {
+		return "", fmt.Errorf("Project %q is not a system", definition.Name)
+	}
+
+	graph, err := newRenderGraph(definition, lookup)
+	if err != nil {
+		return "", err
+	}
+
+	switch format {
+	case DotFormat:
+		return graph.dot(), nil
+	case MermaidFormat:
+		return graph.mermaid(), nil
+	}
+	return "", fmt.Errorf("Unknown render format %q", format)
+}
+
+func newRenderGraph(definition ProjectDefinition, lookup KernelLookup) (*renderGraph, error) {
+	graph := &renderGraph{
+		name: definition.Name,
+	}
+
+	wires := make(map[string]string)
+	wire := func(alias string) string {
+		id, ok := wires[alias]
+		if !ok {
+			id = fmt.Sprintf("w%d", len(wires))
+			wires[alias] = id
+			graph.wires = append(graph.wires, renderNode{id: id, label: alias, shape: "point"})
+		}
+		return id
+	}
+
+	for index, pinterface := range definition.Interfaces {
+		node := renderNode{
+			id:    fmt.Sprintf("i%d", index),
+			label: pinterface.Name,
+			shape: "input",
+		}
+		edge := renderEdge{
+			label:    pinterface.Name + " : " + pinterface.Type.String(),
+			dashed:   pinterface.Optional,
+			directed: true,
+		}
+		if pinterface.Direction == InDirection {
+			edge.from, edge.to = node.id, wire(pinterface.Alias)
+		} else {
+			node.shape = "output"
+			edge.from, edge.to = wire(pinterface.Alias), node.id
+		}
+
+		graph.nodes = append(graph.nodes, node)
+		graph.edges = append(graph.edges, edge)
+	}
+
+	// Types carried by aliases, as declared by the system interfaces
+	aliasTypes := make(map[string]TypeType)
+	for _, pinterface := range definition.Interfaces {
+		if pinterface.Type != InvalidType {
+			aliasTypes[pinterface.Alias] = pinterface.Type
+		}
+	}
+
+	groups := make(map[string]*renderGroup)
+	for index, entity := range definition.Configuration {
+		group, ok := groups[entity.Kernel]
+		if !ok {
+			group = &renderGroup{kernel: entity.Kernel}
+			groups[entity.Kernel] = group
+			graph.groups = append(graph.groups, group)
+		}
+
+		node := renderNode{
+			id:    fmt.Sprintf("e%d", index),
+			label: entity.Name,
+			shape: "entity",
+		}
+		group.nodes = append(group.nodes, node)
+
+		kinterfaces := make(map[string]KernelInterface)
+		if lookup != nil {
+			kernel, err := lookup(entity.Kernel)
+			if err != nil {
+				return nil, fmt.Errorf("Entity %q: %s", entity.Name, err)
+			}
+			for _, kinterface := range kernel.Interfaces {
+				kinterfaces[kinterface.Name] = kinterface
+			}
+		}
+
+		for _, cinterface := range entity.Interfaces {
+			edge := renderEdge{
+				from:  node.id,
+				to:    wire(cinterface.Alias),
+				label: cinterface.Name,
+			}
+
+			if kinterface, ok := kinterfaces[cinterface.Name]; ok {
+				edge.label += " : " + kinterface.Type.String()
+				edge.dashed = kinterface.Optional
+				edge.directed = true
+				if kinterface.Direction == InDirection {
+					edge.from, edge.to = edge.to, edge.from
+				}
+			} else if lookup != nil {
+				return nil, fmt.Errorf("Kernel %q of entity %q has no interface %q", entity.Kernel, entity.Name, cinterface.Name)
+			} else if nodeType, ok := aliasTypes[cinterface.Alias]; ok {
+				edge.label += " : " + nodeType.String()
+			}
+
+			graph.edges = append(graph.edges, edge)
+		}
+	}
+
+	return graph, nil
+}
+
+func (graph *renderGraph) dot() string {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "digraph %s {\n", dotQuote(graph.name))
+	buf.WriteString("\trankdir=LR;\n")
+	buf.WriteString("\tnode [shape=box];\n")
+
+	for _, node := range graph.nodes {
+		shape := "invhouse"
+		if node.shape == "output" {
+			shape = "house"
+		}
+		fmt.Fprintf(&buf, "\t%s [label=%s shape=%s];\n", node.id, dotQuote(node.label), shape)
+	}
+	for _, wire := range graph.wires {
+		fmt.Fprintf(&buf, "\t%s [shape=point xlabel=%s];\n", wire.id, dotQuote(wire.label))
+	}
+	for index, group := range graph.groups {
+		fmt.Fprintf(&buf, "\tsubgraph cluster_%d {\n", index)
+		fmt.Fprintf(&buf, "\t\tlabel=%s;\n", dotQuote(group.kernel))
+		for _, node := range group.nodes {
+			fmt.Fprintf(&buf, "\t\t%s [label=%s];\n", node.id, dotQuote(node.label))
+		}
+		buf.WriteString("\t}\n")
+	}
+	for _, edge := range graph.edges {
+		attributes := []string{"label=" + dotQuote(edge.label)}
+		if edge.dashed {
+			attributes = append(attributes, "style=dashed")
+		}
+		if !edge.directed {
+			attributes = append(attributes, "dir=none")
+		}
+		fmt.Fprintf(&buf, "\t%s -> %s [%s];\n", edge.from, edge.to, strings.Join(attributes, " "))
+	}
+
+	buf.WriteString("}\n")
+	return buf.String()
+}
+
+func (graph *renderGraph) mermaid() string {
+	var buf bytes.Buffer
+
+	buf.WriteString("flowchart LR\n")
+	for _, node := range graph.nodes {
+		if node.shape == "output" {
+			fmt.Fprintf(&buf, "\t%s[/%s/]\n", node.id, mermaidQuote(node.label))
+		} else {
+			fmt.Fprintf(&buf, "\t%s[\\%s\\]\n", node.id, mermaidQuote(node.label))
+		}
+	}
+	for _, wire := range graph.wires {
+		fmt.Fprintf(&buf, "\t%s((%s))\n", wire.id, mermaidQuote(wire.label))
+	}
+	for index, group := range graph.groups {
+		fmt.Fprintf(&buf, "\tsubgraph g%d[%s]\n", index, mermaidQuote(group.kernel))
+		for _, node := range group.nodes {
+			fmt.Fprintf(&buf, "\t\t%s[%s]\n", node.id, mermaidQuote(node.label))
+		}
+		buf.WriteString("\tend\n")
+	}
+	for _, edge := range graph.edges {
+		var arrow string
+		switch {
+		case edge.dashed && edge.directed:
+			arrow = "-.->"
+		case edge.dashed:
+			arrow = "-.-"
+		case edge.directed:
+			arrow = "-->"
+		default:
+			arrow = "---"
+		}
+		fmt.Fprintf(&buf, "\t%s %s|%s| %s\n", edge.from, arrow, mermaidQuote(edge.label), edge.to)
+	}
+
+	return buf.String()
+}
+
+func dotQuote(text string) string {
+	text = strings.Replace(text, `\`, `\\`, -1)
+	text = strings.Replace(text, `"`, `\"`, -1)
+	return `"` + text + `"`
+}
+
+func mermaidQuote(text string) string {
+	return `"` + strings.Replace(text, `"`, "#quot;", -1) + `"`
+}
@@ -0,0 +1,328 @@
+package titanium
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Returns the definition of the named project.
+type ProjectLookup func(project string) (ProjectDefinition, error)
+
+// Looks projects up by fetching their definitions from the server.
+func (client *HttpClient) ProjectLookup(ctx context.Context) ProjectLookup {
+	return func(project string) (ProjectDefinition, error) {
+		return client.GetProjectDefinition(ctx, project)
+	}
+}
+
+// Expands system projects whose entities reference other systems into a flat
+// graph of kernels. Referenced projects are fetched once and cached, so a
+// resolver can be reused for several systems.
+type Resolver struct {
+	lookup ProjectLookup
+
+	mutex    sync.Mutex
+	projects map[string]ProjectDefinition
+}
+
+// A system flattened down to the kernels it eventually runs.
+type ResolvedSystem struct {
+	Project string
+	// Interfaces of the top level system, with types inferred from the kernels
+	// they connect to when not declared.
+	Interfaces []ProjectInterface
+	Entities   []ResolvedEntity
+}
+
+// A kernel running somewhere within a resolved system.
+type ResolvedEntity struct {
+	// Entity names from the top level system down to this one, joined by '/'
+	Path    string
+	Project string
+	Kernel  Kernel
+	// Interfaces of the kernel, connected to aliases of the flattened graph.
+	Interfaces []ConfigurationEntityInterface
+}
+
+// Problems found while resolving a system.
+type ResolveError struct {
+	Project  string
+	Problems []string
+}
+
+func (err *ResolveError) Error() string {
+	return fmt.Sprintf("Failed to resolve %q: %s", err.Project, strings.Join(err.Problems, "; "))
+}
+
+func NewResolver(lookup ProjectLookup) *Resolver {
+	return &Resolver{
+		lookup:   lookup,
+		projects: make(map[string]ProjectDefinition),
+	}
+}
+
+// Returns the definition of a project, fetching it if it is not cached yet.
+func (resolver *Resolver) Project(name string) (ProjectDefinition, error) {
+	resolver.mutex.Lock()
+	definition, ok := resolver.projects[name]
+	resolver.mutex.Unlock()
+	if ok {
+		return definition, nil
+	}
+
+	definition, err := resolver.lookup(name)
+	if err != nil {
+		return definition, err
+	}
+
+	resolver.mutex.Lock()
+	resolver.projects[name] = definition
+	resolver.mutex.Unlock()
+	return definition, nil
+}
+
+// Returns a lookup serving the kernels of cached projects, fetching them as
+// needed.
+func (resolver *Resolver) KernelLookup() KernelLookup {
+	return func(project string) (Kernel, error) {
+		definition, err := resolver.Project(project)
+		if err != nil {
+			return Kernel{}, err
+		}
+		if definition.Kernel == nil {
+			return Kernel{}, fmt.Errorf("Project %q is not a kernel", project)
+		}
+		return *definition.Kernel, nil
+	}
+}
+
+// A system interface at some level of the expansion.
+type resolveInterface struct {
+	project string
+	path    string
+	iface   ProjectInterface
+	alias   string
+}
+
+type resolveState struct {
+	resolver   *Resolver
+	output     *ResolvedSystem
+	interfaces []resolveInterface
+	problems   []string
+}
+
+// Expands the named system project into a flat graph of kernels. Reference
+// cycles between projects and interfaces whose types do not agree across
+// levels are reported as a *ResolveError.
+func (resolver *Resolver) Resolve(project string) (*ResolvedSystem, error) {
+	definition, err := resolver.Project(project)
+	if err != nil {
+		return nil, err
+	}
+	if definition.Type != ProjectTypeToString[ProjectSystemType] {
+		return nil, fmt.Errorf("Project %q is not a system", project)
+	}
+
+	state := &resolveState{
+		resolver: resolver,
+		output: &ResolvedSystem{
+			Project: project,
+		},
+	}
+
+	aliases := make(map[string]string)
+	for _, pinterface := range definition.Interfaces {
+		aliases[pinterface.Alias] = pinterface.Alias
+	}
+	err = state.expand(definition, "", aliases, []string{project})
+	if err != nil {
+		return nil, err
+	}
+
+	state.inferTypes()
+	if len(state.problems) > 0 {
+		return nil, &ResolveError{Project: project, Problems: state.problems}
+	}
+
+	return state.output, nil
+}
+
+func (state *resolveState) problem(format string, args ...interface{}) {
+	state.problems = append(state.problems, fmt.Sprintf(format, args...))
+}
+
+// Adds the entities of a system. aliases maps the aliases bound to the
+// interfaces of the system to aliases of the flattened graph, every other
+// alias is scoped to prefix.
+func (state *resolveState) expand(definition ProjectDefinition, prefix string, aliases map[string]string, stack []string) error {
+	rename := func(alias string) string {
+		if flat, ok := aliases[alias]; ok {
+			return flat
+		}
+		return prefix + alias
+	}
+
+	for _, pinterface := range definition.Interfaces {
+		state.interfaces = append(state.interfaces, resolveInterface{
+			project: definition.Name,
+			path:    strings.TrimSuffix(prefix, "/"),
+			iface:   pinterface,
+			alias:   rename(pinterface.Alias),
+		})
+	}
+
+	for _, entity := range definition.Configuration {
+		path := prefix + entity.Name
+		for _, name := range stack {
+			if name == entity.Kernel {
+				return &ResolveError{
+					Project:  stack[0],
+					Problems: []string{"Project reference cycle: " + strings.Join(append(stack, entity.Kernel), " -> ")},
+				}
+			}
+		}
+
+		child, err := state.resolver.Project(entity.Kernel)
+		if err != nil {
+			return fmt.Errorf("Entity %q: %s", path, err)
+		}
+
+		switch child.Type {
+		case ProjectTypeToString[ProjectKernelType]:
+			if child.Kernel == nil {
+				return fmt.Errorf("Entity %q: project %q has no kernel", path, entity.Kernel)
+			}
+			resolved := ResolvedEntity{
+				Path:    path,
+				Project: entity.Kernel,
+				Kernel:  *child.Kernel,
+			}
+			for _, cinterface := range entity.Interfaces {
+				resolved.Interfaces = append(resolved.Interfaces, ConfigurationEntityInterface{
+					Name:  cinterface.Name,
+					Alias: rename(cinterface.Alias),
+				})
+			}
+			state.output.Entities = append(state.output.Entities, resolved)
+
+		case ProjectTypeToString[ProjectSystemType]:
+			bound := make(map[string]string)
+			for _, cinterface := range entity.Interfaces {
+				bound[cinterface.Name] = rename(cinterface.Alias)
+			}
+
+			childAliases := make(map[string]string)
+			for _, pinterface := range child.Interfaces {
+				flat, ok := bound[pinterface.Name]
+				if !ok {
+					if !pinterface.Optional {
+						state.problem("Interface %q of entity %q is not connected", pinterface.Name, path)
+					}
+					continue
+				}
+				delete(bound, pinterface.Name)
+				childAliases[pinterface.Alias] = flat
+			}
+			for name := range bound {
+				state.problem("System %q of entity %q has no interface %q", entity.Kernel, path, name)
+			}
+
+			err = state.expand(child, path+"/", childAliases, append(stack, entity.Kernel))
+			if err != nil {
+				return err
+			}
+
+		default:
+			return fmt.Errorf("Entity %q: project %q has unknown type %q", path, entity.Kernel, child.Type)
+		}
+	}
+
+	return nil
+}
+
+// Checks that every alias of the flattened graph carries a single type, and
+// fills in the types of top level interfaces that did not declare one.
+func (state *resolveState) inferTypes() {
+	type use struct {
+		nodeType TypeType
+		where    string
+	}
+	uses := make(map[string][]use)
+
+	for _, entity := range state.output.Entities {
+		kinterfaces := make(map[string]KernelInterface)
+		for _, kinterface := range entity.Kernel.Interfaces {
+			kinterfaces[kinterface.Name] = kinterface
+		}
+
+		for _, cinterface := range entity.Interfaces {
+			kinterface, ok := kinterfaces[cinterface.Name]
+			if !ok {
+				state.problem("Kernel %q of entity %q has no interface %q", entity.Project, entity.Path, cinterface.Name)
+				continue
+			}
+			uses[cinterface.Alias] = append(uses[cinterface.Alias], use{
+				nodeType: kinterface.Type,
+				where:    fmt.Sprintf("interface %q of entity %q", cinterface.Name, entity.Path),
+			})
+		}
+	}
+
+	// Kernels sharing an alias must agree with each other
+	aliases := make([]string, 0, len(uses))
+	for alias := range uses {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	for _, alias := range aliases {
+		first := uses[alias][0]
+		for _, other := range uses[alias][1:] {
+			if other.nodeType != first.nodeType {
+				state.problem("Alias %q connects %s %s to %s %s", alias, first.nodeType, first.where, other.nodeType, other.where)
+			}
+		}
+	}
+
+	// Declared system interface types must agree with the kernels below them
+	top := make(map[string]TypeType)
+	for _, rinterface := range state.interfaces {
+		kernelUses := uses[rinterface.alias]
+		if len(kernelUses) == 0 {
+			continue
+		}
+
+		inferred := kernelUses[0].nodeType
+		declared := rinterface.iface.Type
+		if declared != InvalidType && declared != inferred {
+			where := fmt.Sprintf("system %q", rinterface.project)
+			if rinterface.path != "" {
+				where += fmt.Sprintf(" (entity %q)", rinterface.path)
+			}
+			state.problem("Interface %q of %s is declared %s, but connects to %s %s", rinterface.iface.Name, where, declared, inferred, kernelUses[0].where)
+		}
+		if rinterface.path == "" {
+			top[rinterface.iface.Name] = inferred
+		}
+	}
+
+	for _, rinterface := range state.interfaces {
+		if rinterface.path != "" {
+			continue
+		}
+
+		pinterface := rinterface.iface
+		if pinterface.Type == InvalidType {
+			pinterface.Type = top[pinterface.Name]
+		}
+		if pinterface.Type == InvalidType {
+			state.problem("Type of interface %q can not be inferred, it connects to no kernel", pinterface.Name)
+		} else if err := pinterface.Validate(); err != nil {
+			state.problem("%s", err)
+		}
+		pinterface.Alias = rinterface.alias
+		state.output.Interfaces = append(state.output.Interfaces, pinterface)
+	}
+}
@@ -0,0 +1,124 @@
+package titanium
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Compute resources reserved for, and limits enforced on, each instance of a
+// kernel. Zero values leave the platform defaults in place.
+type Resources struct {
+	// Number of CPU cores, fractions allowed
+	CPU float64 `json:"cpu,omitempty"`
+	// Memory limit, instances exceeding it are killed
+	Memory ByteSize `json:"memory,omitempty"`
+	// Scratch disk space
+	Disk ByteSize `json:"disk,omitempty"`
+	// Wall clock limit, instances running longer are stopped
+	Timeout Duration `json:"timeout,omitempty"`
+}
+
+// A number of bytes, written with an optional unit such as '512Mi' or '2G'.
+type ByteSize int64
+
+// A time.Duration, written as in '1h30m'.
+type Duration time.Duration
+
+var (
+	ErrInvalidResources = errors.New("Resource requests must not be negative")
+
+	byteSizeUnits = []struct {
+		suffix string
+		size   int64
+	}{
+		{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30}, {"Ti", 1 << 40},
+		{"K", 1e3}, {"M", 1e6}, {"G", 1e9}, {"T", 1e12},
+	}
+)
+
+func (resources Resources) Validate() error {
+	if resources.CPU < 0 || resources.Memory < 0 || resources.Disk < 0 || resources.Timeout < 0 {
+		return ErrInvalidResources
+	}
+	return nil
+}
+
+// Returns the resources with every field set in override replacing the one in
+// resources.
+func (resources Resources) Merge(override Resources) Resources {
+	if override.CPU != 0 {
+		resources.CPU = override.CPU
+	}
+	if override.Memory != 0 {
+		resources.Memory = override.Memory
+	}
+	if override.Disk != 0 {
+		resources.Disk = override.Disk
+	}
+	if override.Timeout != 0 {
+		resources.Timeout = override.Timeout
+	}
+	return resources
+}
+
+func ParseByteSize(text string) (ByteSize, error) {
+	text = strings.TrimSpace(text)
+	multiplier := int64(1)
+	for _, unit := range byteSizeUnits {
+		if strings.HasSuffix(text, unit.suffix) {
+			text = strings.TrimSuffix(text, unit.suffix)
+			multiplier = unit.size
+			break
+		}
+	}
+
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("Invalid byte size %q", text)
+	}
+	return ByteSize(value * float64(multiplier)), nil
+}
+
+func (size ByteSize) String() string {
+	// Largest binary unit the size is a multiple of
+	for index := 3; index >= 0 && size != 0; index-- {
+		unit := byteSizeUnits[index]
+		if int64(size)%unit.size == 0 {
+			return strconv.FormatInt(int64(size)/unit.size, 10) + unit.suffix
+		}
+	}
+	return strconv.FormatInt(int64(size), 10)
+}
+
+func (size ByteSize) MarshalText() ([]byte, error) {
+	return []byte(size.String()), nil
+}
+
+func (size *ByteSize) UnmarshalText(text []byte) error {
+	value, err := ParseByteSize(string(text))
+	if err != nil {
+		return err
+	}
+	*size = value
+	return nil
+}
+
+func (duration Duration) String() string {
+	return time.Duration(duration).String()
+}
+
+func (duration Duration) MarshalText() ([]byte, error) {
+	return []byte(duration.String()), nil
+}
+
+func (duration *Duration) UnmarshalText(text []byte) error {
+	value, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*duration = Duration(value)
+	return nil
+}
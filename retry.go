@@ -0,0 +1,217 @@
+package titanium
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/atomosio/common"
+)
+
+var (
+	ErrInvalidRetryAttempts = errors.New("Retry policy requires atleast one attempt")
+	ErrInvalidRetryBackoff  = errors.New("Retry policy backoff must not be negative")
+)
+
+// Controls how failed instances of a cluster are retried.
+type RetryPolicy struct {
+	// Total number of attempts per instance, including the first one
+	MaxAttempts int
+	// Delay before the first retry
+	Backoff time.Duration
+	// Factor the delay grows by after each retry, values below 1 are treated
+	// as 1
+	Multiplier float64
+	// Upper bound for the delay, unbounded if zero
+	MaxBackoff time.Duration
+
+	// Substrings matched against the comments of the Error log entries of a
+	// failed instance, and against the type of resource limit events such as
+	// 'OutOfMemory'. The instance is retried if any of them matches. An empty
+	// list makes every failure retryable.
+	RetryableErrors []string
+}
+
+type OutRetryPolicy struct {
+	MaxAttempts     int      `json:"max_attempts"`
+	Backoff         float64  `json:"backoff"`
+	Multiplier      float64  `json:"multiplier,omitempty"`
+	MaxBackoff      float64  `json:"max_backoff,omitempty"`
+	RetryableErrors []string `json:"retryable_errors,omitempty"`
+}
+
+type RetryInstancesRequest struct {
+	Instances []string `json:"instances"`
+}
+
+type RetryInstancesResponse struct {
+	Response
+	Instances []string `json:"instances"`
+}
+
+func RetryPolicyToOutRetryPolicy(policy RetryPolicy) OutRetryPolicy {
+	return OutRetryPolicy{
+		MaxAttempts:     policy.MaxAttempts,
+		Backoff:         policy.Backoff.Seconds(),
+		Multiplier:      policy.Multiplier,
+		MaxBackoff:      policy.MaxBackoff.Seconds(),
+		RetryableErrors: policy.RetryableErrors,
+	}
+}
+
+func OutRetryPolicyToRetryPolicy(policy OutRetryPolicy) RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:     policy.MaxAttempts,
+		Backoff:         time.Duration(policy.Backoff * float64(time.Second)),
+		Multiplier:      policy.Multiplier,
+		MaxBackoff:      time.Duration(policy.MaxBackoff * float64(time.Second)),
+		RetryableErrors: policy.RetryableErrors,
+	}
+}
+
+func (policy RetryPolicy) Validate() error {
+	if policy.MaxAttempts < 1 {
+		return ErrInvalidRetryAttempts
+	}
+	if policy.Backoff < 0 || policy.MaxBackoff < 0 {
+		return ErrInvalidRetryBackoff
+	}
+	return nil
+}
+
+// Returns the delay before the given retry, starting at 1.
+func (policy RetryPolicy) Delay(retry int) time.Duration {
+	multiplier := policy.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	delay := float64(policy.Backoff)
+	for i := 1; i < retry; i++ {
+		delay *= multiplier
+		if policy.MaxBackoff > 0 && delay >= float64(policy.MaxBackoff) {
+			return policy.MaxBackoff
+		}
+	}
+
+	return time.Duration(delay)
+}
+
+// Returns when the failed instance may be retried under the policy: Delay
+// after it stopped. Instances without a Stopped event may be retried at once.
+func (policy RetryPolicy) RetryTime(instance Instance) time.Time {
+	retry := instance.Attempt
+	if retry < 1 {
+		retry = 1
+	}
+
+	stopped, ok := instance.EventTime("Stopped")
+	if !ok {
+		return time.Time{}
+	}
+	return stopped.Add(policy.Delay(retry))
+}
+
+// Returns whether the failure of the instance is retryable under the policy.
+// Instances that did not fail, or have used up MaxAttempts, are never
+// retryable. A policy without MaxAttempts does not limit attempts.
+func (policy RetryPolicy) IsRetryable(instance Instance) bool {
+	if !instance.IsFailed() {
+		return false
+	}
+	if policy.MaxAttempts > 0 && instance.Attempt >= policy.MaxAttempts {
+		return false
+	}
+	if len(policy.RetryableErrors) == 0 {
+		return true
+	}
+
+	for _, entry := range instance.Errors() {
+		for _, pattern := range policy.RetryableErrors {
+			if strings.Contains(entry.Comment, pattern) || entry.Type == pattern {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Resubmits the failed instances of a cluster, leaving the rest of the cluster
+// untouched. If the cluster was created with a retry policy, only failures it
+// considers retryable are resubmitted, once its backoff has elapsed; failures
+// still waiting are left for a later call. Instances that have already been
+// retried are skipped. Returns the ids of the new instances, each of which
+// records the attempt it belongs to in its log.
+func (client *HttpClient) RetryFailedInstances(ctx context.Context, clusterId int64) ([]int64, error) {
+	cluster, err := client.GetClusterContext(ctx, clusterId)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := RetryPolicy{}
+	if cluster.Retry != nil {
+		policy = OutRetryPolicyToRetryPolicy(*cluster.Retry)
+	}
+
+	instances := make(map[int64]Instance)
+	for _, instanceId := range cluster.Instances {
+		instance, err := client.GetInstanceContext(ctx, instanceId)
+		if err != nil {
+			return nil, err
+		}
+		instances[instanceId] = instance
+	}
+
+	request := RetryInstancesRequest{}
+	retried := retriedInstances(instances)
+	now := time.Now()
+	for _, instanceId := range cluster.Instances {
+		instance := instances[instanceId]
+		if retried[instanceId] || !policy.IsRetryable(instance) || now.Before(policy.RetryTime(instance)) {
+			continue
+		}
+		request.Instances = append(request.Instances, strconv.FormatInt(instanceId, 10))
+	}
+	if len(request.Instances) == 0 {
+		return nil, nil
+	}
+
+	// Post and unmarshal response
+	response := RetryInstancesResponse{}
+	addr := fmt.Sprintf("%s%d/retry", ClustersEndpoint, clusterId)
+	err = client.DoMethodAndUnmarshalContext(ctx, "POST", addr, &request, &response)
+	if err != nil {
+		return nil, err
+	}
+	if response.Code != common.Success {
+		return nil, errors.New(response.Description)
+	}
+
+	output := make([]int64, len(response.Instances))
+	for index, str := range response.Instances {
+		output[index], err = strconv.ParseInt(str, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return output, nil
+}
+
+// Returns the ids of the instances another instance is a retry of.
+func retriedInstances(instances map[int64]Instance) map[int64]bool {
+	output := make(map[int64]bool)
+	for _, instance := range instances {
+		if instance.RetryOf == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(instance.RetryOf, 10, 64)
+		if err == nil {
+			output[id] = true
+		}
+	}
+	return output
+}
@@ -0,0 +1,291 @@
+package titanium
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	neturl "net/url"
+	"strconv"
+	"time"
+
+	"github.com/atomosio/common"
+)
+
+// What to do when a schedule fires while the cluster started by its previous
+// firing has not stopped yet.
+const (
+	InvalidOverlapPolicy = iota
+	AllowOverlapPolicy   // Start another cluster alongside the running one
+	SkipOverlapPolicy    // Skip this firing
+	QueueOverlapPolicy   // Start once the running cluster stops
+	ReplaceOverlapPolicy // Stop the running cluster and start a new one
+)
+
+var (
+	OverlapPolicyStrings = []string{
+		"Invalid",
+		"Allow",
+		"Skip",
+		"Queue",
+		"Replace",
+	}
+	OverlapPolicyStringsTo = map[string]int{
+		OverlapPolicyStrings[InvalidOverlapPolicy]: InvalidOverlapPolicy,
+		OverlapPolicyStrings[AllowOverlapPolicy]:   AllowOverlapPolicy,
+		OverlapPolicyStrings[SkipOverlapPolicy]:    SkipOverlapPolicy,
+		OverlapPolicyStrings[QueueOverlapPolicy]:   QueueOverlapPolicy,
+		OverlapPolicyStrings[ReplaceOverlapPolicy]: ReplaceOverlapPolicy,
+	}
+
+	ErrInvalidOverlapPolicy = errors.New("Invalid schedule overlap policy")
+	ErrInvalidFireCount     = errors.New("Number of fire times must not be negative")
+	ErrScheduleDependsOn    = errors.New("Scheduled clusters can not depend on other clusters")
+)
+
+const (
+	SchedulesEndpoint = "schedules/"
+)
+
+// A recurring submission of a cluster.
+type Schedule struct {
+	Response
+
+	IdString  string               `json:"schedule_id"`
+	Name      string               `json:"name"`
+	Cron      string               `json:"cron"`
+	TimeZone  string               `json:"time_zone"`
+	Overlap   string               `json:"overlap"`
+	Paused    bool                 `json:"paused"`
+	Cluster   CreateClusterRequest `json:"cluster"`
+	LastFired int64                `json:"last_fired,omitempty"`
+
+	Id int64
+}
+
+// Describes a schedule to be created with CreateSchedule.
+type ScheduleSpec struct {
+	Name string
+	// Five field cron expression, see ParseCron
+	Cron string
+	// IANA time zone the cron expression is evaluated in, defaults to UTC
+	TimeZone string
+	// One of the *OverlapPolicy constants. CreateSchedule defaults it to
+	// SkipOverlapPolicy, Validate rejects InvalidOverlapPolicy.
+	Overlap int
+
+	// Cluster submitted every time the schedule fires. DependsOn must be
+	// empty: every firing would wait on the same, long stopped, clusters.
+	Cluster ClusterSpec
+}
+
+type CreateScheduleRequest struct {
+	Name     string               `json:"name"`
+	Cron     string               `json:"cron"`
+	TimeZone string               `json:"time_zone"`
+	Overlap  string               `json:"overlap"`
+	Cluster  CreateClusterRequest `json:"cluster"`
+}
+
+type CreateScheduleResponse struct {
+	Response
+	ScheduleId string `json:"schedule_id,omitempty"`
+}
+
+type ListSchedulesResponse struct {
+	Response
+	Schedules []Schedule `json:"schedules"`
+}
+
+type UpdateScheduleRequest struct {
+	Paused bool `json:"paused"`
+}
+
+// Checks the cron expression, time zone, overlap policy and cluster of the
+// spec.
+func (spec ScheduleSpec) Validate() error {
+	_, err := ParseCron(spec.Cron)
+	if err != nil {
+		return err
+	}
+
+	_, err = time.LoadLocation(spec.TimeZone)
+	if err != nil {
+		return err
+	}
+
+	if spec.Overlap <= InvalidOverlapPolicy || spec.Overlap > ReplaceOverlapPolicy {
+		return ErrInvalidOverlapPolicy
+	}
+
+	if len(spec.Cluster.DependsOn) != 0 {
+		return ErrScheduleDependsOn
+	}
+
+	return spec.Cluster.Validate()
+}
+
+// Registers a recurring cluster submission.
+func (client *HttpClient) CreateSchedule(ctx context.Context, spec ScheduleSpec) (Schedule, error) {
+	if spec.TimeZone == "" {
+		spec.TimeZone = "UTC"
+	}
+	if spec.Overlap == InvalidOverlapPolicy {
+		spec.Overlap = SkipOverlapPolicy
+	}
+
+	err := spec.Validate()
+	if err != nil {
+		return Schedule{}, err
+	}
+
+	// Each firing gets its own idempotency key server side
+	request := CreateScheduleRequest{
+		Name:     spec.Name,
+		Cron:     spec.Cron,
+		TimeZone: spec.TimeZone,
+		Overlap:  OverlapPolicyStrings[spec.Overlap],
+		Cluster:  spec.Cluster.request(),
+	}
+	request.Cluster.IdempotencyKey = ""
+
+	// Post and unmarshal response
+	var response CreateScheduleResponse
+	err = client.DoMethodAndUnmarshalContext(ctx, "POST", SchedulesEndpoint, &request, &response)
+	if err != nil {
+		return Schedule{}, err
+	}
+
+	if response.Code != common.Success {
+		return Schedule{}, errors.New(response.Description)
+	}
+
+	scheduleId, err := strconv.ParseInt(response.ScheduleId, 10, 64)
+	if err != nil {
+		return Schedule{}, err
+	}
+	return client.GetSchedule(ctx, scheduleId)
+}
+
+func (client *HttpClient) GetSchedule(ctx context.Context, id int64) (Schedule, error) {
+	var output Schedule
+
+	// Get and unmarshal
+	addr := fmt.Sprintf("%s%d", SchedulesEndpoint, id)
+	err := client.DoEmptyMethodAndUnmarshalContext(ctx, "GET", addr, &output)
+	if err != nil {
+		return output, err
+	}
+
+	if output.Response.Code != common.Success {
+		return output, errors.New("Failed to get schedule information: " + output.Response.Description)
+	}
+
+	output.Id, err = strconv.ParseInt(output.IdString, 10, 64)
+	return output, err
+}
+
+// Lists the schedules submitting clusters of the project. An empty project
+// lists every schedule visible to the token.
+func (client *HttpClient) ListSchedules(ctx context.Context, project string) ([]Schedule, error) {
+	var response ListSchedulesResponse
+
+	// Get and unmarshal
+	addr := SchedulesEndpoint
+	if project != "" {
+		addr += "?project=" + neturl.QueryEscape(project)
+	}
+	err := client.DoEmptyMethodAndUnmarshalContext(ctx, "GET", addr, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.Code != common.Success {
+		return nil, errors.New(response.Description)
+	}
+
+	for index := range response.Schedules {
+		schedule := &response.Schedules[index]
+		schedule.Id, err = strconv.ParseInt(schedule.IdString, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return response.Schedules, nil
+}
+
+// Stops the schedule from firing until it is resumed.
+func (client *HttpClient) PauseSchedule(ctx context.Context, id int64) error {
+	return client.setSchedulePaused(ctx, id, true)
+}
+
+func (client *HttpClient) ResumeSchedule(ctx context.Context, id int64) error {
+	return client.setSchedulePaused(ctx, id, false)
+}
+
+func (client *HttpClient) setSchedulePaused(ctx context.Context, id int64, paused bool) error {
+	request := UpdateScheduleRequest{
+		Paused: paused,
+	}
+	response := &Response{}
+	addr := fmt.Sprintf("%s%d", SchedulesEndpoint, id)
+	err := client.DoMethodAndUnmarshalContext(ctx, "PATCH", addr, request, response)
+	if err != nil {
+		return err
+	}
+	if response.Code != common.Success {
+		return errors.New(response.Description)
+	}
+
+	return nil
+}
+
+func (client *HttpClient) DeleteSchedule(ctx context.Context, id int64) error {
+	response := &Response{}
+	addr := fmt.Sprintf("%s%d", SchedulesEndpoint, id)
+	err := client.DoEmptyMethodAndUnmarshalContext(ctx, "DELETE", addr, response)
+	if err != nil {
+		return err
+	}
+	if response.Code != common.Success {
+		return errors.New(response.Description)
+	}
+
+	return nil
+}
+
+// Evaluates the next count times the schedule fires after from, without
+// contacting the server.
+func (schedule Schedule) NextFireTimes(from time.Time, count int) ([]time.Time, error) {
+	return NextFireTimes(schedule.Cron, schedule.TimeZone, from, count)
+}
+
+// Evaluates the next count times the cron expression fires after from, in the
+// given time zone.
+func NextFireTimes(cron, timeZone string, from time.Time, count int) ([]time.Time, error) {
+	if count < 0 {
+		return nil, ErrInvalidFireCount
+	}
+
+	expression, err := ParseCron(cron)
+	if err != nil {
+		return nil, err
+	}
+
+	location, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return nil, err
+	}
+
+	output := make([]time.Time, 0, count)
+	t := from.In(location)
+	for len(output) < count {
+		t, err = expression.Next(t)
+		if err != nil {
+			return output, err
+		}
+		output = append(output, t)
+	}
+
+	return output, nil
+}
@@ -0,0 +1,148 @@
+package titanium
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/atomosio/common"
+)
+
+// Text substituted for secret values in logs.
+const RedactedText = "[REDACTED]"
+
+var (
+	ErrInvalidSecretName = errors.New("Secret names may only contain letters, digits and '_'")
+)
+
+// Names a stored secret. Secret values are never returned by the server.
+type SecretInfo struct {
+	Name string `json:"name"`
+	// Unix timestamps
+	Created int64 `json:"created"`
+	Updated int64 `json:"updated"`
+}
+
+type SetSecretRequest struct {
+	Value string `json:"value"`
+}
+
+type ListSecretsResponse struct {
+	Response
+	Secrets []SecretInfo `json:"secrets"`
+}
+
+// Returns the placeholder that stands for the named secret of the project in
+// Env values and secret interface bindings. The platform substitutes the
+// value when starting an instance.
+func SecretRef(name string) string {
+	return "${secret:" + name + "}"
+}
+
+func validateSecretName(name string) error {
+	if name == "" {
+		return ErrInvalidSecretName
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_') {
+			return ErrInvalidSecretName
+		}
+	}
+	return nil
+}
+
+// Stores a secret for the project, replacing any previous value. The value is
+// redacted from the logs of this client from now on.
+func (client *HttpClient) SetSecret(ctx context.Context, project, name, value string) error {
+	err := validateSecretName(name)
+	if err != nil {
+		return err
+	}
+	client.Redact(value)
+
+	request := SetSecretRequest{
+		Value: value,
+	}
+
+	//send request
+	response := Response{}
+	addr := fmt.Sprintf("%s/%s/secrets/%s", ProjectsEndpoint, project, name)
+	err = client.DoMethodAndUnmarshalContext(ctx, "PUT", addr, &request, &response)
+	if err != nil {
+		return err
+	}
+
+	if response.Code != common.Success {
+		return errors.New(response.Description)
+	}
+
+	return nil
+}
+
+// Lists the secrets of the project, without their values.
+func (client *HttpClient) ListSecrets(ctx context.Context, project string) ([]SecretInfo, error) {
+	var response ListSecretsResponse
+
+	// Get and unmarshal
+	addr := fmt.Sprintf("%s/%s/secrets/", ProjectsEndpoint, project)
+	err := client.DoEmptyMethodAndUnmarshalContext(ctx, "GET", addr, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.Code != common.Success {
+		return nil, errors.New(response.Description)
+	}
+
+	return response.Secrets, nil
+}
+
+func (client *HttpClient) DeleteSecret(ctx context.Context, project, name string) error {
+	err := validateSecretName(name)
+	if err != nil {
+		return err
+	}
+
+	response := Response{}
+	addr := fmt.Sprintf("%s/%s/secrets/%s", ProjectsEndpoint, project, name)
+	err = client.DoEmptyMethodAndUnmarshalContext(ctx, "DELETE", addr, &response)
+	if err != nil {
+		return err
+	}
+
+	if response.Code != common.Success {
+		return errors.New(response.Description)
+	}
+
+	return nil
+}
+
+// Registers values that must never appear in the output of this client: its
+// debug logs and the instance log entries it sends or receives. Kernels
+// should register the secrets they were started with.
+func (client *HttpClient) Redact(values ...string) {
+	client.redactMutex.Lock()
+	defer client.redactMutex.Unlock()
+
+	for _, value := range values {
+		if value != "" {
+			client.redactions = append(client.redactions, value)
+		}
+	}
+}
+
+// Replaces the token and every registered secret value in text.
+func (client *HttpClient) redact(text string) string {
+	if client.Token != "" {
+		text = strings.Replace(text, client.Token, RedactedText, -1)
+	}
+
+	client.redactMutex.RLock()
+	defer client.redactMutex.RUnlock()
+
+	for _, value := range client.redactions {
+		text = strings.Replace(text, value, RedactedText, -1)
+	}
+	return text
+}
@@ -2,21 +2,43 @@ package titanium
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"strings"
 	//	"github.com/atomosio/common"
 	"io"
-	"io/ioutil"
+	"log/slog"
 	"net/http"
 	neturl "net/url"
+	"os"
+	"sync"
 )
 
 type HttpClient struct {
 	endpoint string
 	Token    string
 	client   *http.Client
-	log      bool
+
+	// Requests are logged when set
+	logger    *slog.Logger
+	logBodies bool
+
+	middleware []Middleware
+	limiter    *limiter
+	// client wrapped by the limiter and middleware, rebuilt when they change
+	sender *http.Client
+
+	// Identical GETs in flight are coalesced, responses cached if set.
+	// Mutations bump the generation of the resource they modify, so results
+	// read before are neither shared nor stored.
+	flights     flightGroup
+	generations resourceGenerations
+	cache       *responseCache
+
+	// Secret values kept out of logs
+	redactMutex sync.RWMutex
+	redactions  []string
 
 	//URL Related data
 	scheme string
@@ -57,48 +79,59 @@ func NewHttpClient(endpoint, token string) (client *HttpClient) {
 func newHttpClient(endpoint, token string, log bool) (client *HttpClient) {
 	urlURL, _ := neturl.Parse(endpoint)
 
-	return &HttpClient{
+	client = &HttpClient{
 		Token:    token,
 		endpoint: endpoint,
 		client:   &http.Client{},
-		log:      log,
 
 		scheme: urlURL.Scheme,
 		host:   urlURL.Host,
 		path:   urlURL.Path,
 	}
+	client.buildHTTPClient()
+	if log {
+		client.StartLogging()
+	}
+	return client
 }
 
+// Logs every request at debug level, as text on stderr. Use WithLogger for
+// control over the destination and format.
 func (client *HttpClient) StartLogging() *HttpClient {
-	client.log = true
-	return client
+	handler := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+	})
+	return client.WithLogger(slog.New(handler))
 }
 
+// Logs a message at debug level, with secret values redacted.
 func (client *HttpClient) Logf(format string, args ...interface{}) {
-	if client.log {
-		fmt.Printf(format, args...)
+	if client.logger != nil {
+		message := strings.TrimSuffix(fmt.Sprintf(format, args...), "\n")
+		client.logger.Debug(client.redact(message))
 	}
 }
 
-func (client *HttpClient) prepEmptyRequest(method string, url *URL) (req *http.Request, err error) {
-	return client.prepRequest(method, url, nil)
+func (client *HttpClient) prepEmptyRequest(ctx context.Context, method string, url *URL) (req *http.Request, err error) {
+	return client.prepRequest(ctx, method, url, nil)
 }
 
-func (client *HttpClient) prepRequest(method string, url *URL, body io.Reader) (req *http.Request, err error) {
-	req, err = http.NewRequest(method, url.String(), body)
+func (client *HttpClient) prepRequest(ctx context.Context, method string, url *URL, body io.Reader) (req *http.Request, err error) {
+	req, err = http.NewRequestWithContext(ctx, method, url.String(), body)
 	if err != nil {
 		return nil, err
 	}
 
 	req.Header.Set("Authorization", client.Token)
 
-	client.Logf("HttpClient %s -> %s\n", method, url)
-
 	return req, nil
 }
 
 func (client *HttpClient) do(req *http.Request) (*http.Response, error) {
-	return client.client.Do(req)
+	if client.logger == nil {
+		return client.httpClient().Do(req)
+	}
+	return client.doAndLog(req)
 }
 
 // Did we get a 2XX respond code?
@@ -106,44 +139,25 @@ func statusGood(status int) bool {
 	return status >= 200 && status <= 299
 }
 
-func (client *HttpClient) doEmptyRequestAndReadResponse(method, format string, args ...interface{}) (data []byte, err error) {
+func (client *HttpClient) doEmptyRequestAndReadResponse(ctx context.Context, method, format string, args ...interface{}) (data []byte, err error) {
 	url := client.NewURL(fmt.Sprintf(format, args...))
 
 	// Prepare request
-	req, err := client.prepEmptyRequest(method, url)
+	req, err := client.prepEmptyRequest(ctx, method, url)
 	if err != nil {
 		client.Logf("Failed PrepRequest: %s\n", err)
 		return nil, err
 	}
 
 	// Do request
-	resp, err := client.do(req)
-	if err != nil {
-		return nil, err
-	}
-
-	data, err = ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return data, err
-	}
-
-	return data, nil
+	return client.clientDoRequestAndReadResponse(req)
 }
 
 func (client *HttpClient) clientDoRequestAndReadResponse(req *http.Request) ([]byte, error) {
-	resp, err := client.do(req)
-	if err != nil {
-		return nil, err
-	}
-	data, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return data, err
-	}
-
-	return data, nil
+	return client.doCachedAndRead(req)
 }
 
-func (client *HttpClient) doRequestAndReadResponse(method string, jsonVar interface{}, addrfmt string, args ...interface{}) (data []byte, err error) {
+func (client *HttpClient) doRequestAndReadResponse(ctx context.Context, method string, jsonVar interface{}, addrfmt string, args ...interface{}) (data []byte, err error) {
 	addr := fmt.Sprintf(addrfmt, args...)
 	url := client.NewURL(addr)
 
@@ -155,7 +169,7 @@ func (client *HttpClient) doRequestAndReadResponse(method string, jsonVar interf
 	reader := bytes.NewReader(marshalledData)
 
 	// Prepare request
-	req, err := client.prepRequest(method, url, reader)
+	req, err := client.prepRequest(ctx, method, url, reader)
 	if err != nil {
 		client.Logf("Failed PrepRequest: %s\n", err)
 		return nil, err
@@ -170,19 +184,53 @@ func (client *HttpClient) doRequestAndReadResponse(method string, jsonVar interf
 	return data, nil
 }
 
+// Sends body as is, with the given content type, and unmarshals the JSON
+// response into i.
+func (client *HttpClient) DoRawMethodAndUnmarshalContext(ctx context.Context, method, addr, contentType string, body io.Reader, i interface{}) error {
+	url := client.NewURL(addr)
+
+	// Prepare request
+	req, err := client.prepRequest(ctx, method, url, body)
+	if err != nil {
+		client.Logf("Failed PrepRequest: %s\n", err)
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	// Do request
+	data, err := client.clientDoRequestAndReadResponse(req)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, i)
+}
+
 func (client *HttpClient) NewURL(path string) *URL {
+	// Anything after a '?' is the query string
+	var query string
+	if index := strings.Index(path, "?"); index >= 0 {
+		path, query = path[:index], path[index+1:]
+	}
+
 	path = strings.Replace(path, "//", "/", -1)
 	return &URL{
 		URL: neturl.URL{
-			Scheme: client.scheme,
-			Host:   client.host,
-			Path:   client.path + path,
+			Scheme:   client.scheme,
+			Host:     client.host,
+			Path:     client.path + path,
+			RawQuery: query,
 		},
 	}
 }
 
 func (client *HttpClient) DoEmptyMethodAndUnmarshal(method, addr string, i interface{}) error {
-	data, err := client.doEmptyRequestAndReadResponse(method, addr)
+	return client.DoEmptyMethodAndUnmarshalContext(context.Background(), method, addr, i)
+}
+
+// Same as DoEmptyMethodAndUnmarshal, but the request is bound to ctx.
+func (client *HttpClient) DoEmptyMethodAndUnmarshalContext(ctx context.Context, method, addr string, i interface{}) error {
+	data, err := client.doEmptyRequestAndReadResponse(ctx, method, "%s", addr)
 	if err != nil {
 		return err
 	}
@@ -196,8 +244,12 @@ func (client *HttpClient) DoEmptyMethodAndUnmarshal(method, addr string, i inter
 }
 
 func (client *HttpClient) DoMethodAndUnmarshal(method, addr string, jsonVar interface{}, i interface{}) error {
+	return client.DoMethodAndUnmarshalContext(context.Background(), method, addr, jsonVar, i)
+}
 
-	data, err := client.doRequestAndReadResponse(method, jsonVar, addr)
+// Same as DoMethodAndUnmarshal, but the request is bound to ctx.
+func (client *HttpClient) DoMethodAndUnmarshalContext(ctx context.Context, method, addr string, jsonVar interface{}, i interface{}) error {
+	data, err := client.doRequestAndReadResponse(ctx, method, jsonVar, "%s", addr)
 	if err != nil {
 		return err
 	}
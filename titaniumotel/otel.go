@@ -0,0 +1,288 @@
+// Package titaniumotel instruments titanium.HttpClient with OpenTelemetry.
+//
+// Every request gets a client span attributed with the API endpoint, method,
+// cluster, instance or project it addresses and the Response.Code returned.
+// Request counts, latencies and errors are recorded as metrics, and the W3C
+// trace context is propagated in request headers.
+//
+// Kernels continue the trace of the cluster that started them. Nothing does
+// this automatically: callers add the trace context to the environment of the
+// cluster with InjectEnv before creating it,
+//
+//	spec.Env = titaniumotel.InjectEnv(ctx, spec.Env)
+//	cluster, err := client.CreateCluster(ctx, spec)
+//
+// and ContextFromEnv picks it up within the instance.
+//
+// Tests can pass providers backed by tracetest.NewInMemoryExporter and
+// metric.NewManualReader from the OpenTelemetry SDK through the options.
+package titaniumotel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/atomosio/common"
+	"github.com/atomosio/titanium-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/atomosio/titanium-go/titaniumotel"
+
+// Attribute keys
+const (
+	EndpointKey     = attribute.Key("titanium.endpoint")
+	ClusterIdKey    = attribute.Key("titanium.cluster_id")
+	InstanceIdKey   = attribute.Key("titanium.instance_id")
+	ProjectKey      = attribute.Key("titanium.project")
+	ResponseCodeKey = attribute.Key("titanium.response_code")
+	MethodKey       = attribute.Key("http.request.method")
+	StatusCodeKey   = attribute.Key("http.response.status_code")
+	ErrorTypeKey    = attribute.Key("error.type")
+)
+
+// Environment variables carrying the trace context into kernels
+const (
+	TraceParentEnv = "TRACEPARENT"
+	TraceStateEnv  = "TRACESTATE"
+)
+
+// Endpoints recognized in request paths
+var endpoints = []string{
+	titanium.InstancesEndpoint,
+	titanium.ClustersEndpoint,
+	titanium.ProjectsEndpoint,
+	titanium.TokensEndpoint,
+	titanium.SchedulesEndpoint,
+	titanium.ImagesEndpoint,
+}
+
+type config struct {
+	tracerProvider trace.TracerProvider
+	meterProvider  metric.MeterProvider
+	propagator     propagation.TextMapPropagator
+}
+
+type Option func(*config)
+
+// Uses provider instead of the global tracer provider.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(c *config) {
+		c.tracerProvider = provider
+	}
+}
+
+// Uses provider instead of the global meter provider.
+func WithMeterProvider(provider metric.MeterProvider) Option {
+	return func(c *config) {
+		c.meterProvider = provider
+	}
+}
+
+// Uses propagator instead of W3C trace context.
+func WithPropagator(propagator propagation.TextMapPropagator) Option {
+	return func(c *config) {
+		c.propagator = propagator
+	}
+}
+
+func newConfig(options []Option) *config {
+	c := &config{
+		tracerProvider: otel.GetTracerProvider(),
+		meterProvider:  otel.GetMeterProvider(),
+		propagator:     propagation.TraceContext{},
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+type instruments struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+	requests   metric.Int64Counter
+	errors     metric.Int64Counter
+	duration   metric.Float64Histogram
+}
+
+// Instruments the client and returns it.
+func Instrument(client *titanium.HttpClient, options ...Option) (*titanium.HttpClient, error) {
+	middleware, err := Middleware(options...)
+	if err != nil {
+		return nil, err
+	}
+	return client.Use(middleware), nil
+}
+
+// Returns the instrumentation as a titanium.Middleware.
+func Middleware(options ...Option) (titanium.Middleware, error) {
+	c := newConfig(options)
+	meter := c.meterProvider.Meter(instrumentationName)
+
+	i := &instruments{
+		tracer:     c.tracerProvider.Tracer(instrumentationName),
+		propagator: c.propagator,
+	}
+
+	var err error
+	i.requests, err = meter.Int64Counter("titanium.client.requests",
+		metric.WithDescription("Requests sent to the Titanium API"))
+	if err != nil {
+		return nil, err
+	}
+	i.errors, err = meter.Int64Counter("titanium.client.errors",
+		metric.WithDescription("Requests that failed, by API response code or error type"))
+	if err != nil {
+		return nil, err
+	}
+	i.duration, err = meter.Float64Histogram("titanium.client.duration",
+		metric.WithDescription("Latency of requests to the Titanium API"),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10))
+	if err != nil {
+		return nil, err
+	}
+
+	return func(next http.RoundTripper) http.RoundTripper {
+		return titanium.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return i.roundTrip(next, req)
+		})
+	}, nil
+}
+
+func (i *instruments) roundTrip(next http.RoundTripper, req *http.Request) (*http.Response, error) {
+	endpoint, attrs := describe(req)
+	attrs = append(attrs, MethodKey.String(req.Method))
+
+	ctx, span := i.tracer.Start(req.Context(), "titanium "+req.Method+" "+endpoint,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...))
+	defer span.End()
+
+	req = req.Clone(ctx)
+	i.propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	start := time.Now()
+	resp, err := next.RoundTrip(req)
+	elapsed := time.Since(start).Seconds()
+
+	metricAttrs := []attribute.KeyValue{EndpointKey.String(endpoint), MethodKey.String(req.Method)}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		errAttrs := append(metricAttrs, ErrorTypeKey.String("transport"))
+		i.errors.Add(ctx, 1, metric.WithAttributes(errAttrs...))
+		i.requests.Add(ctx, 1, metric.WithAttributes(metricAttrs...))
+		i.duration.Record(ctx, elapsed, metric.WithAttributes(metricAttrs...))
+		return nil, err
+	}
+
+	span.SetAttributes(StatusCodeKey.Int(resp.StatusCode))
+	metricAttrs = append(metricAttrs, StatusCodeKey.Int(resp.StatusCode))
+
+	// Peek at the API response code
+	if code, ok := responseCode(resp); ok {
+		span.SetAttributes(ResponseCodeKey.Int(code))
+		metricAttrs = append(metricAttrs, ResponseCodeKey.Int(code))
+		if code != common.Success {
+			span.SetStatus(codes.Error, "API response code "+strconv.Itoa(code))
+			i.errors.Add(ctx, 1, metric.WithAttributes(metricAttrs...))
+		}
+	} else if resp.StatusCode >= 400 {
+		span.SetStatus(codes.Error, resp.Status)
+		i.errors.Add(ctx, 1, metric.WithAttributes(metricAttrs...))
+	}
+
+	i.requests.Add(ctx, 1, metric.WithAttributes(metricAttrs...))
+	i.duration.Record(ctx, elapsed, metric.WithAttributes(metricAttrs...))
+	return resp, nil
+}
+
+// Works out the endpoint a request addresses, along with the ids found in its
+// path.
+func describe(req *http.Request) (string, []attribute.KeyValue) {
+	segments := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	for index, segment := range segments {
+		for _, endpoint := range endpoints {
+			if segment+"/" != endpoint {
+				continue
+			}
+
+			name := strings.TrimSuffix(endpoint, "/")
+			attrs := []attribute.KeyValue{EndpointKey.String(name)}
+			if index+1 < len(segments) && segments[index+1] != "" {
+				id := segments[index+1]
+				switch endpoint {
+				case titanium.ClustersEndpoint:
+					attrs = append(attrs, ClusterIdKey.String(id))
+				case titanium.InstancesEndpoint:
+					attrs = append(attrs, InstanceIdKey.String(id))
+				case titanium.ProjectsEndpoint:
+					attrs = append(attrs, ProjectKey.String(id))
+				}
+			}
+			return name, attrs
+		}
+	}
+
+	return "unknown", []attribute.KeyValue{EndpointKey.String("unknown")}
+}
+
+func responseCode(resp *http.Response) (int, bool) {
+	data, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(data))
+	if err != nil {
+		return 0, false
+	}
+
+	var response struct {
+		Code *int `json:"code"`
+	}
+	if json.Unmarshal(data, &response) != nil || response.Code == nil {
+		return 0, false
+	}
+	return *response.Code, true
+}
+
+// Adds the trace context of ctx to env, so the instances of a cluster created
+// with it continue the trace. Returns env, allocating it if nil.
+func InjectEnv(ctx context.Context, env map[string]string) map[string]string {
+	if env == nil {
+		env = make(map[string]string)
+	}
+
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	if value := carrier.Get("traceparent"); value != "" {
+		env[TraceParentEnv] = value
+	}
+	if value := carrier.Get("tracestate"); value != "" {
+		env[TraceStateEnv] = value
+	}
+	return env
+}
+
+// Returns ctx carrying the trace context the instance was started with, for
+// kernels to attach the spans of their lifecycle calls, such as
+// SetInstanceActiveContext, to the trace of the cluster.
+func ContextFromEnv(ctx context.Context) context.Context {
+	carrier := propagation.MapCarrier{
+		"traceparent": os.Getenv(TraceParentEnv),
+		"tracestate":  os.Getenv(TraceStateEnv),
+	}
+	return propagation.TraceContext{}.Extract(ctx, carrier)
+}
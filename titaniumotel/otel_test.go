@@ -0,0 +1,114 @@
+package titaniumotel_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/atomosio/common"
+	"github.com/atomosio/titanium-go"
+	"github.com/atomosio/titanium-go/titaniumotel"
+	"github.com/atomosio/titanium-go/titaniumtest"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func instrumentedClient(t *testing.T, server *titaniumtest.Server) (*titanium.HttpClient, *tracetest.InMemoryExporter) {
+	t.Helper()
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	client, err := titaniumotel.Instrument(server.Client("token"), titaniumotel.WithTracerProvider(provider))
+	if err != nil {
+		t.Fatalf("Instrument: %s", err)
+	}
+	return client, exporter
+}
+
+func TestSpanAttributes(t *testing.T) {
+	server := titaniumtest.NewServer()
+	defer server.Close()
+	server.PutProject(titanium.ProjectDefinition{
+		Name: "wordcount",
+		Type: titanium.ProjectTypeToString[titanium.ProjectKernelType],
+		Kernel: &titanium.Kernel{
+			Command: "./count.sh",
+			Interfaces: []titanium.KernelInterface{
+				{Name: "count", Path: "/out/count", Type: titanium.FileType, Direction: titanium.OutDirection},
+			},
+		},
+	})
+
+	tests := []struct {
+		name    string
+		project string
+		success bool
+	}{
+		{name: "success", project: "wordcount", success: true},
+		{name: "failure", project: "missing", success: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, exporter := instrumentedClient(t, server)
+
+			_, err := client.GetProjectDefinition(context.Background(), test.project)
+			if (err == nil) != test.success {
+				t.Fatalf("GetProjectDefinition: got error %v", err)
+			}
+
+			spans := exporter.GetSpans()
+			if len(spans) != 1 {
+				t.Fatalf("got %d spans, want 1", len(spans))
+			}
+			span := spans[0]
+			if span.SpanKind != trace.SpanKindClient {
+				t.Errorf("got span kind %s, want client", span.SpanKind)
+			}
+
+			attrs := make(map[attribute.Key]attribute.Value)
+			for _, attr := range span.Attributes {
+				attrs[attr.Key] = attr.Value
+			}
+			want := map[attribute.Key]string{
+				titaniumotel.EndpointKey: "projects",
+				titaniumotel.ProjectKey:  test.project,
+				titaniumotel.MethodKey:   "GET",
+			}
+			for key, value := range want {
+				if got := attrs[key].AsString(); got != value {
+					t.Errorf("attribute %s: got %q, want %q", key, got, value)
+				}
+			}
+
+			code, ok := attrs[titaniumotel.ResponseCodeKey]
+			if !ok {
+				t.Fatalf("no %s attribute", titaniumotel.ResponseCodeKey)
+			}
+			if (int(code.AsInt64()) == common.Success) != test.success {
+				t.Errorf("attribute %s: got %d", titaniumotel.ResponseCodeKey, code.AsInt64())
+			}
+		})
+	}
+}
+
+func TestEnvRoundTrip(t *testing.T) {
+	provider := sdktrace.NewTracerProvider()
+	ctx, span := provider.Tracer("test").Start(context.Background(), "create cluster")
+	defer span.End()
+
+	env := titaniumotel.InjectEnv(ctx, nil)
+	for _, name := range []string{titaniumotel.TraceParentEnv, titaniumotel.TraceStateEnv} {
+		t.Setenv(name, env[name])
+	}
+
+	parent := trace.SpanContextFromContext(titaniumotel.ContextFromEnv(context.Background()))
+	if !parent.IsRemote() {
+		t.Error("recovered span context is not remote")
+	}
+	if parent.TraceID() != span.SpanContext().TraceID() || parent.SpanID() != span.SpanContext().SpanID() {
+		t.Errorf("got span context %s/%s, want %s/%s", parent.TraceID(), parent.SpanID(),
+			span.SpanContext().TraceID(), span.SpanContext().SpanID())
+	}
+}
@@ -0,0 +1,284 @@
+// Package titaniumprom exports the state of Titanium clusters and instances as
+// Prometheus metrics.
+//
+// An Exporter periodically lists clusters and their instances through the
+// client and keeps the following metrics up to date:
+//
+//	titanium_clusters{project,status}                    clusters by status
+//	titanium_instances{project,status}                   instances by status
+//	titanium_instance_queue_duration_seconds{project}    time from Queued to Started
+//	titanium_instance_run_duration_seconds{project}      time from Started to Stopped
+//	titanium_exporter_polls_total{result}                polls by success or failure
+//	titanium_exporter_last_poll_timestamp_seconds        time of the last successful poll
+//
+// Durations are derived from the instance LogEntry timestamps and observed
+// once per instance.
+package titaniumprom
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/atomosio/titanium-go"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "titanium"
+
+// Buckets of the duration histograms, in seconds, from a second to a day
+var DurationBuckets = []float64{1, 5, 15, 30, 60, 300, 900, 1800, 3600, 3 * 3600, 6 * 3600, 12 * 3600, 24 * 3600}
+
+type Exporter struct {
+	client  *titanium.HttpClient
+	project string
+
+	clusters      *prometheus.GaugeVec
+	instances     *prometheus.GaugeVec
+	queueDuration *prometheus.HistogramVec
+	runDuration   *prometheus.HistogramVec
+	polls         *prometheus.CounterVec
+	lastPoll      prometheus.Gauge
+
+	mutex sync.Mutex
+	// Instances seen so far, kept to observe each duration only once and to
+	// avoid fetching stopped instances again
+	seen map[int64]*instanceState
+}
+
+type instanceState struct {
+	project       string
+	status        string
+	queueObserved bool
+	runObserved   bool
+}
+
+// Creates an exporter for the clusters of project, or of every project
+// accessible with the client token if project is empty. The exporter must be
+// registered with a prometheus.Registerer, and polled with Poll or Run.
+func NewExporter(client *titanium.HttpClient, project string) *Exporter {
+	return &Exporter{
+		client:  client,
+		project: project,
+
+		clusters: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "clusters",
+			Help:      "Number of clusters by project and status.",
+		}, []string{"project", "status"}),
+		instances: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "instances",
+			Help:      "Number of instances by project and status.",
+		}, []string{"project", "status"}),
+		queueDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "instance_queue_duration_seconds",
+			Help:      "Time instances spent queued before starting.",
+			Buckets:   DurationBuckets,
+		}, []string{"project"}),
+		runDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "instance_run_duration_seconds",
+			Help:      "Time instances spent running before stopping.",
+			Buckets:   DurationBuckets,
+		}, []string{"project"}),
+		polls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "exporter",
+			Name:      "polls_total",
+			Help:      "Polls of the Titanium API by result.",
+		}, []string{"result"}),
+		lastPoll: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "exporter",
+			Name:      "last_poll_timestamp_seconds",
+			Help:      "Unix time of the last successful poll.",
+		}),
+
+		seen: make(map[int64]*instanceState),
+	}
+}
+
+func (exporter *Exporter) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		exporter.clusters,
+		exporter.instances,
+		exporter.queueDuration,
+		exporter.runDuration,
+		exporter.polls,
+		exporter.lastPoll,
+	}
+}
+
+// Implements prometheus.Collector.
+func (exporter *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	for _, collector := range exporter.collectors() {
+		collector.Describe(ch)
+	}
+}
+
+// Implements prometheus.Collector.
+func (exporter *Exporter) Collect(ch chan<- prometheus.Metric) {
+	for _, collector := range exporter.collectors() {
+		collector.Collect(ch)
+	}
+}
+
+// Polls every interval until ctx is done, which is the only error returned.
+// Failed polls are counted and logged through the client.
+func (exporter *Exporter) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := exporter.Poll(ctx)
+		if err != nil && ctx.Err() == nil {
+			exporter.client.Logf("Failed to poll Titanium: %s\n", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Lists clusters and their instances once and updates the metrics. The gauges
+// are left untouched if the poll fails.
+func (exporter *Exporter) Poll(ctx context.Context) error {
+	exporter.mutex.Lock()
+	defer exporter.mutex.Unlock()
+
+	err := exporter.poll(ctx)
+	if err != nil {
+		exporter.polls.WithLabelValues("failure").Inc()
+		return err
+	}
+
+	exporter.polls.WithLabelValues("success").Inc()
+	exporter.lastPoll.SetToCurrentTime()
+	return nil
+}
+
+func (exporter *Exporter) poll(ctx context.Context) error {
+	clusters, err := exporter.client.ListClusters(ctx, exporter.project)
+	if err != nil {
+		return err
+	}
+
+	clusterCounts := newCounts(titanium.ClusterStatusStrings)
+	instanceCounts := newCounts(titanium.InstanceStatusStrings)
+	current := make(map[int64]bool)
+
+	for _, cluster := range clusters {
+		clusterCounts.add(cluster.Project, cluster.Status)
+
+		for _, id := range cluster.Instances {
+			state, err := exporter.instance(ctx, cluster.Project, id)
+			if err != nil {
+				return err
+			}
+			instanceCounts.add(state.project, state.status)
+			current[id] = true
+		}
+	}
+
+	// Forget instances that are no longer listed
+	for id := range exporter.seen {
+		if !current[id] {
+			delete(exporter.seen, id)
+		}
+	}
+
+	clusterCounts.set(exporter.clusters)
+	instanceCounts.set(exporter.instances)
+	return nil
+}
+
+// Returns the state of an instance, observing its durations the first time
+// they are known.
+func (exporter *Exporter) instance(ctx context.Context, project string, id int64) (*instanceState, error) {
+	state, ok := exporter.seen[id]
+	if ok && state.queueObserved && state.runObserved {
+		// Stopped instances do not change
+		return state, nil
+	}
+	if !ok {
+		state = &instanceState{project: project}
+		exporter.seen[id] = state
+	}
+
+	instance, err := exporter.client.GetInstanceContext(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	state.status = instance.Status
+
+	queued, isQueued := instance.EventTime("Queued")
+	started, isStarted := instance.EventTime("Started")
+	stopped, isStopped := instance.EventTime("Stopped")
+
+	if !state.queueObserved && isStarted {
+		if isQueued {
+			exporter.queueDuration.WithLabelValues(project).Observe(started.Sub(queued).Seconds())
+		}
+		state.queueObserved = true
+	}
+	if !state.runObserved && isStopped {
+		if isStarted {
+			exporter.runDuration.WithLabelValues(project).Observe(stopped.Sub(started).Seconds())
+		}
+		state.runObserved = true
+		// Stopped before ever starting, there is no queue duration to observe
+		state.queueObserved = true
+	}
+
+	return state, nil
+}
+
+// Counts by project and status. Every status of a project that has been seen
+// is reported, so that statuses dropping to zero are exported as such.
+type counts struct {
+	statuses []string
+	values   map[string]map[string]int
+}
+
+func newCounts(statuses []string) *counts {
+	return &counts{
+		statuses: statuses,
+		values:   make(map[string]map[string]int),
+	}
+}
+
+func (c *counts) add(project, status string) {
+	if c.values[project] == nil {
+		c.values[project] = make(map[string]int)
+	}
+	c.values[project][status]++
+}
+
+func (c *counts) set(gauge *prometheus.GaugeVec) {
+	gauge.Reset()
+	for project, values := range c.values {
+		for _, status := range c.statuses[1:] {
+			gauge.WithLabelValues(project, status).Set(float64(values[status]))
+		}
+		for status, value := range values {
+			// Statuses unknown to this client
+			if value > 0 && !contains(c.statuses[1:], status) {
+				gauge.WithLabelValues(project, status).Set(float64(value))
+			}
+		}
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,356 @@
+package titaniumtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/atomosio/titanium-go"
+)
+
+// Whether a Recorder talks to the server or replays a cassette.
+type Mode int
+
+const (
+	ReplayMode Mode = iota // Answer requests from the cassette
+	RecordMode             // Send requests on and record them
+)
+
+var ErrUnexpectedRequest = errors.New("Request not found in cassette")
+
+// Headers whose values are never written to a cassette
+var redactedHeaders = []string{"Authorization", "Cookie", "Set-Cookie"}
+
+// Request/response pairs recorded to a file.
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+type RecordedRequest struct {
+	Method string      `json:"method"`
+	Path   string      `json:"path"`
+	Query  string      `json:"query,omitempty"`
+	Header http.Header `json:"header,omitempty"`
+	// JSON bodies are kept as is, for cassettes to be readable and editable
+	JSON json.RawMessage `json:"json,omitempty"`
+	Body string          `json:"body,omitempty"`
+}
+
+type RecordedResponse struct {
+	Status int             `json:"status"`
+	Header http.Header     `json:"header,omitempty"`
+	JSON   json.RawMessage `json:"json,omitempty"`
+	Body   string          `json:"body,omitempty"`
+}
+
+// Decides whether a request matches a recorded one. body is the request body,
+// redacted the same way recorded bodies are.
+type Matcher func(req *http.Request, body []byte, recorded RecordedRequest) bool
+
+func MatchMethod(req *http.Request, body []byte, recorded RecordedRequest) bool {
+	return req.Method == recorded.Method
+}
+
+func MatchPath(req *http.Request, body []byte, recorded RecordedRequest) bool {
+	return req.URL.Path == recorded.Path
+}
+
+func MatchQuery(req *http.Request, body []byte, recorded RecordedRequest) bool {
+	return req.URL.RawQuery == recorded.Query
+}
+
+// Compares bodies, JSON ones regardless of formatting and key order.
+func MatchBody(req *http.Request, body []byte, recorded RecordedRequest) bool {
+	if len(recorded.JSON) > 0 {
+		return jsonEqual(body, recorded.JSON)
+	}
+	return string(body) == recorded.Body
+}
+
+// Matchers used unless MatchOn is called
+var DefaultMatchers = []Matcher{MatchMethod, MatchPath, MatchQuery}
+
+// Records the requests of a client to a cassette, or replays them from one.
+//
+//	recorder, err := titaniumtest.NewRecorder("testdata/cluster.json", titaniumtest.ReplayMode)
+//	client := titanium.NewHttpClient(endpoint, token).Use(recorder.Middleware())
+//
+// Authorization and cookie headers are never recorded, nor are the values of
+// password, token and secret fields of JSON bodies and values given to Redact.
+// Each recorded interaction is replayed once, in the order recorded among those
+// matching a request.
+type Recorder struct {
+	path string
+	mode Mode
+
+	// When set, requests missing from the cassette fail with
+	// ErrUnexpectedRequest instead of being sent to the server.
+	Strict bool
+
+	mutex      sync.Mutex
+	cassette   Cassette
+	used       []bool
+	matchers   []Matcher
+	redactions []string
+}
+
+// Creates a recorder for the cassette at path. In ReplayMode the cassette is
+// loaded and must exist, in RecordMode it is overwritten by Save.
+func NewRecorder(path string, mode Mode) (*Recorder, error) {
+	recorder := &Recorder{
+		path:     path,
+		mode:     mode,
+		matchers: DefaultMatchers,
+	}
+
+	if mode == ReplayMode {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(data, &recorder.cassette)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load cassette %s: %s", path, err)
+		}
+		recorder.used = make([]bool, len(recorder.cassette.Interactions))
+	}
+
+	return recorder, nil
+}
+
+// Replaces the matchers requests are replayed by.
+func (recorder *Recorder) MatchOn(matchers ...Matcher) *Recorder {
+	recorder.matchers = matchers
+	return recorder
+}
+
+// Replaces values, such as tokens, with titanium.RedactedText wherever they
+// appear in recorded requests and responses.
+func (recorder *Recorder) Redact(values ...string) *Recorder {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	for _, value := range values {
+		if value != "" {
+			recorder.redactions = append(recorder.redactions, value)
+		}
+	}
+	return recorder
+}
+
+// Returns the middleware recording or replaying the requests of a client.
+func (recorder *Recorder) Middleware() titanium.Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return titanium.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return recorder.roundTrip(next, req)
+		})
+	}
+}
+
+// Writes the recorded interactions to the cassette.
+func (recorder *Recorder) Save() error {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	data, err := json.MarshalIndent(recorder.cassette, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(recorder.path, append(data, '\n'), 0644)
+}
+
+// Returns the interactions of the cassette that have not been replayed.
+func (recorder *Recorder) Unused() []Interaction {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	var output []Interaction
+	for index, used := range recorder.used {
+		if !used {
+			output = append(output, recorder.cassette.Interactions[index])
+		}
+	}
+	return output
+}
+
+func (recorder *Recorder) roundTrip(next http.RoundTripper, req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req = req.Clone(req.Context())
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	if recorder.mode == RecordMode {
+		return recorder.record(next, req, body)
+	}
+
+	interaction, ok := recorder.match(req, body)
+	if ok {
+		return interaction.Response.response(req), nil
+	}
+	if recorder.Strict {
+		return nil, fmt.Errorf("%w: %s %s", ErrUnexpectedRequest, req.Method, req.URL.RequestURI())
+	}
+	return next.RoundTrip(req)
+}
+
+func (recorder *Recorder) record(next http.RoundTripper, req *http.Request, body []byte) (*http.Response, error) {
+	resp, err := next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(data))
+	if err != nil {
+		return resp, err
+	}
+
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	interaction := Interaction{
+		Request: RecordedRequest{
+			Method: req.Method,
+			Path:   recorder.redact(req.URL.Path),
+			Query:  recorder.redact(req.URL.RawQuery),
+			Header: recorder.redactHeader(req.Header),
+		},
+		Response: RecordedResponse{
+			Status: resp.StatusCode,
+			Header: recorder.redactHeader(resp.Header),
+		},
+	}
+	interaction.Request.JSON, interaction.Request.Body = recorder.redactBody(body)
+	interaction.Response.JSON, interaction.Response.Body = recorder.redactBody(data)
+
+	recorder.cassette.Interactions = append(recorder.cassette.Interactions, interaction)
+	recorder.used = append(recorder.used, true)
+	return resp, nil
+}
+
+// Returns the first unused interaction matching the request, marking it used.
+func (recorder *Recorder) match(req *http.Request, body []byte) (Interaction, bool) {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	jsonBody, textBody := recorder.redactBody(body)
+	if len(jsonBody) > 0 {
+		body = jsonBody
+	} else {
+		body = []byte(textBody)
+	}
+
+	for index, interaction := range recorder.cassette.Interactions {
+		if recorder.used[index] {
+			continue
+		}
+
+		matches := true
+		for _, matcher := range recorder.matchers {
+			if !matcher(req, body, interaction.Request) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			recorder.used[index] = true
+			return interaction, true
+		}
+	}
+	return Interaction{}, false
+}
+
+func (recorder *Recorder) redact(text string) string {
+	for _, value := range recorder.redactions {
+		text = strings.Replace(text, value, titanium.RedactedText, -1)
+	}
+	return text
+}
+
+func (recorder *Recorder) redactHeader(header http.Header) http.Header {
+	output := make(http.Header, len(header))
+	for name, values := range header {
+		// Redaction may change the length of bodies
+		if name == "Content-Length" {
+			continue
+		}
+		for _, value := range values {
+			output[name] = append(output[name], recorder.redact(value))
+		}
+	}
+	for _, name := range redactedHeaders {
+		if _, ok := output[name]; ok {
+			output[name] = []string{titanium.RedactedText}
+		}
+	}
+	if len(output) == 0 {
+		return nil
+	}
+	return output
+}
+
+// Returns the redacted body as JSON if it is, as text otherwise.
+func (recorder *Recorder) redactBody(body []byte) (json.RawMessage, string) {
+	if len(body) == 0 {
+		return nil, ""
+	}
+
+	text := recorder.redact(string(titanium.RedactJSON(body)))
+	if json.Valid([]byte(text)) {
+		return json.RawMessage(text), ""
+	}
+	return nil, text
+}
+
+func (recorded RecordedResponse) response(req *http.Request) *http.Response {
+	body := []byte(recorded.Body)
+	if len(recorded.JSON) > 0 {
+		body = recorded.JSON
+	}
+
+	header := recorded.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recorded.Status, http.StatusText(recorded.Status)),
+		StatusCode:    recorded.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+func jsonEqual(a, b []byte) bool {
+	var valueA, valueB interface{}
+	if json.Unmarshal(a, &valueA) != nil || json.Unmarshal(b, &valueB) != nil {
+		return false
+	}
+
+	normalA, _ := json.Marshal(valueA)
+	normalB, _ := json.Marshal(valueB)
+	return bytes.Equal(normalA, normalB)
+}
@@ -0,0 +1,379 @@
+package titaniumtest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/atomosio/titanium-go"
+)
+
+// Faults a FaultInjector can inject
+const (
+	NoFault          = iota
+	LatencyFault     // Response delayed
+	ResetFault       // Connection reset before the request reached the server
+	LateResetFault   // Connection reset after the server handled the request
+	ServerErrorFault // 5xx response from a failing gateway or server
+	TruncateFault    // Connection lost while reading the response body
+	MalformedFault   // Response body that is not valid JSON
+	StaleFault       // Response to an earlier identical GET
+)
+
+var FaultStrings = []string{
+	"None",
+	"Latency",
+	"Reset",
+	"LateReset",
+	"ServerError",
+	"Truncate",
+	"Malformed",
+	"Stale",
+}
+
+var ErrInvalidFaultPolicy = errors.New("Fault probabilities must be between 0 and 1 and add up to at most 1")
+
+// Number of responses kept per URL to serve stale ones from
+var StaleHistory = 5
+
+// Probabilities, between 0 and 1, of each fault being injected into a
+// request. Latency is added independently of the other faults, of which at
+// most one is injected per request, so their probabilities must add up to at
+// most 1.
+type FaultPolicy struct {
+	Latency float64
+	// Injected latencies are drawn uniformly up to MaxLatency
+	MaxLatency time.Duration
+
+	// Half of the resets happen after the server handled the request, as a
+	// LateResetFault.
+	Reset       float64
+	ServerError float64
+	Truncate    float64
+	Malformed   float64
+	// Only GET requests to URLs answered before are served stale responses
+	Stale float64
+}
+
+func (policy FaultPolicy) Validate() error {
+	probabilities := []float64{policy.Latency, policy.Reset, policy.ServerError, policy.Truncate, policy.Malformed, policy.Stale}
+	for _, probability := range probabilities {
+		if probability < 0 || probability > 1 {
+			return ErrInvalidFaultPolicy
+		}
+	}
+	if policy.Reset+policy.ServerError+policy.Truncate+policy.Malformed+policy.Stale > 1 {
+		return ErrInvalidFaultPolicy
+	}
+	if policy.MaxLatency < 0 {
+		return ErrInvalidFaultPolicy
+	}
+	return nil
+}
+
+// A fault injected into a request.
+type InjectedFault struct {
+	Method string
+	Path   string
+	// One of the *Fault constants
+	Fault   int
+	Latency time.Duration
+}
+
+func (fault InjectedFault) String() string {
+	return fmt.Sprintf("%s %s: %s", fault.Method, fault.Path, FaultStrings[fault.Fault])
+}
+
+// Injects faults into the requests of a client, chosen at random by policy.
+//
+//	injector, err := titaniumtest.NewFaultInjector(42, titaniumtest.FaultPolicy{Reset: 0.1})
+//	...
+//	err = injector.SetEndpointPolicy(titanium.InstancesEndpoint, titaniumtest.FaultPolicy{Stale: 0.5})
+//	...
+//	client := server.Client(token).Use(injector.Middleware())
+//
+// Faults are drawn from a generator seeded with the given seed, so a test
+// sending the same requests in the same order sees the same faults.
+type FaultInjector struct {
+	mutex     sync.Mutex
+	rand      *rand.Rand
+	policy    FaultPolicy
+	basePath  string
+	endpoints map[string]FaultPolicy
+	history   map[string][][]byte
+	injected  []InjectedFault
+}
+
+// Creates an injector applying policy to every endpoint without a policy of
+// its own.
+func NewFaultInjector(seed int64, policy FaultPolicy) (*FaultInjector, error) {
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &FaultInjector{
+		rand:      rand.New(rand.NewSource(seed)),
+		policy:    policy,
+		basePath:  "/",
+		endpoints: make(map[string]FaultPolicy),
+		history:   make(map[string][][]byte),
+	}, nil
+}
+
+// Applies policy to requests to endpoint, one of the titanium *Endpoint
+// constants.
+func (injector *FaultInjector) SetEndpointPolicy(endpoint string, policy FaultPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+
+	injector.mutex.Lock()
+	defer injector.mutex.Unlock()
+
+	injector.endpoints[endpoint] = policy
+	return nil
+}
+
+// Sets the path of the titanium endpoint the client sends requests to, which
+// endpoint policies are matched below. Defaults to '/', as for a Server.
+func (injector *FaultInjector) SetBasePath(path string) *FaultInjector {
+	injector.mutex.Lock()
+	defer injector.mutex.Unlock()
+
+	injector.basePath = path
+	return injector
+}
+
+// Returns the faults injected so far, in order.
+func (injector *FaultInjector) Injected() []InjectedFault {
+	injector.mutex.Lock()
+	defer injector.mutex.Unlock()
+
+	return append([]InjectedFault(nil), injector.injected...)
+}
+
+// Returns the injector as middleware for titanium.HttpClient.Use.
+func (injector *FaultInjector) Middleware() titanium.Middleware {
+	return injector.Wrap
+}
+
+// Wraps next, injecting faults into the requests sent through it.
+func (injector *FaultInjector) Wrap(next http.RoundTripper) http.RoundTripper {
+	return titanium.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return injector.roundTrip(next, req)
+	})
+}
+
+func (injector *FaultInjector) roundTrip(next http.RoundTripper, req *http.Request) (*http.Response, error) {
+	key := req.URL.String()
+	fault, latency, position, stale := injector.draw(req, key)
+
+	if latency > 0 {
+		timer := time.NewTimer(latency)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+
+	switch fault {
+	case ResetFault:
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, resetError()
+	case StaleFault:
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return response(req, http.StatusOK, stale, nil), nil
+	}
+
+	resp, err := next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if req.Method == "GET" && fault == NoFault && resp.StatusCode == http.StatusOK {
+		injector.remember(key, data)
+	}
+
+	switch fault {
+	case LateResetFault:
+		return nil, resetError()
+	case ServerErrorFault:
+		status := []int{
+			http.StatusInternalServerError,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		}[position%4]
+		resp := response(req, status, []byte(http.StatusText(status)), nil)
+		resp.Header.Set("Content-Type", "text/plain")
+		return resp, nil
+	case TruncateFault:
+		cut := 0
+		if len(data) > 0 {
+			cut = position % len(data)
+		}
+		return response(req, resp.StatusCode, data[:cut], io.ErrUnexpectedEOF), nil
+	case MalformedFault:
+		// Control characters are invalid anywhere in JSON
+		at := 0
+		if len(data) > 0 {
+			at = position % len(data)
+		}
+		malformed := append(append(append([]byte{}, data[:at]...), 0), data[at:]...)
+		return response(req, resp.StatusCode, malformed, nil), nil
+	}
+
+	resp.Body = ioutil.NopCloser(bytes.NewReader(data))
+	return resp, nil
+}
+
+// Decides the fault injected into a request. position is a random number used
+// to pick where and how the fault applies.
+func (injector *FaultInjector) draw(req *http.Request, key string) (fault int, latency time.Duration, position int, stale []byte) {
+	injector.mutex.Lock()
+	defer injector.mutex.Unlock()
+
+	policy := injector.policyFor(req.URL.Path)
+
+	// Always draw the same numbers, for faults to not depend on the policy of
+	// other endpoints
+	latencyDraw := injector.rand.Float64()
+	latencyAmount := injector.rand.Float64()
+	faultDraw := injector.rand.Float64()
+	lateDraw := injector.rand.Intn(2)
+	position = injector.rand.Int()
+
+	if latencyDraw < policy.Latency {
+		latency = time.Duration(latencyAmount * float64(policy.MaxLatency))
+		injector.injected = append(injector.injected, InjectedFault{
+			Method:  req.Method,
+			Path:    req.URL.Path,
+			Fault:   LatencyFault,
+			Latency: latency,
+		})
+	}
+
+	limit := 0.0
+	for _, candidate := range []struct {
+		fault       int
+		probability float64
+	}{
+		{ResetFault, policy.Reset},
+		{ServerErrorFault, policy.ServerError},
+		{TruncateFault, policy.Truncate},
+		{MalformedFault, policy.Malformed},
+		{StaleFault, policy.Stale},
+	} {
+		limit += candidate.probability
+		if faultDraw < limit {
+			fault = candidate.fault
+			break
+		}
+	}
+
+	switch fault {
+	case ResetFault:
+		if lateDraw == 1 {
+			fault = LateResetFault
+		}
+	case StaleFault:
+		history := injector.history[key]
+		if req.Method != "GET" || len(history) == 0 {
+			fault = NoFault
+			break
+		}
+		stale = history[position%len(history)]
+	}
+
+	if fault != NoFault {
+		injector.injected = append(injector.injected, InjectedFault{
+			Method: req.Method,
+			Path:   req.URL.Path,
+			Fault:  fault,
+		})
+	}
+	return fault, latency, position, stale
+}
+
+// Returns the policy of the endpoint addressed by path.
+func (injector *FaultInjector) policyFor(path string) FaultPolicy {
+	if !strings.HasPrefix(path, injector.basePath) {
+		return injector.policy
+	}
+
+	path = strings.TrimPrefix(strings.TrimPrefix(path, injector.basePath), "/")
+	policy, longest := injector.policy, -1
+	// The longest matching endpoint wins, whatever the map order
+	for endpoint, endpointPolicy := range injector.endpoints {
+		if strings.HasPrefix(path, endpoint) && len(endpoint) > longest {
+			policy, longest = endpointPolicy, len(endpoint)
+		}
+	}
+	return policy
+}
+
+func (injector *FaultInjector) remember(key string, data []byte) {
+	injector.mutex.Lock()
+	defer injector.mutex.Unlock()
+
+	history := append(injector.history[key], data)
+	if len(history) > StaleHistory {
+		history = history[len(history)-StaleHistory:]
+	}
+	injector.history[key] = history
+}
+
+func resetError() error {
+	return &net.OpError{
+		Op:  "read",
+		Net: "tcp",
+		Err: syscall.ECONNRESET,
+	}
+}
+
+// Builds a response whose body reads data, then fails with err if not nil.
+func response(req *http.Request, status int, data []byte, err error) *http.Response {
+	var body io.Reader = bytes.NewReader(data)
+	if err != nil {
+		body = io.MultiReader(body, &errorReader{err: err})
+	}
+
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode: status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       ioutil.NopCloser(body),
+		Request:    req,
+	}
+}
+
+type errorReader struct {
+	err error
+}
+
+func (reader *errorReader) Read(p []byte) (int, error) {
+	return 0, reader.err
+}
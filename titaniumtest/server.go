@@ -0,0 +1,469 @@
+// Package titaniumtest provides an in-memory Titanium server for exercising
+// the client without talking to the real service.
+package titaniumtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/atomosio/common"
+	"github.com/atomosio/titanium-go"
+)
+
+const (
+	failureCode = common.Success + 1
+)
+
+type Server struct {
+	*httptest.Server
+
+	mutex     sync.Mutex
+	nextId    int64
+	clusters  map[int64]*Cluster
+	instances map[int64]*titanium.Instance
+	keys      map[string]int64
+	projects  map[string]*titanium.ProjectDefinition
+	images    map[string]titanium.Image
+	drop      int
+}
+
+// A cluster as stored by the fake server.
+type Cluster struct {
+	Id        int64
+	Request   titanium.CreateClusterRequest
+	Status    string
+	Clusters  []int64
+	Instances []int64
+}
+
+// Starts a new fake server. Close must be called once done with it.
+func NewServer() *Server {
+	server := &Server{
+		nextId:    1,
+		clusters:  make(map[int64]*Cluster),
+		instances: make(map[int64]*titanium.Instance),
+		keys:      make(map[string]int64),
+		projects:  make(map[string]*titanium.ProjectDefinition),
+		images: map[string]titanium.Image{
+			titanium.DefaultImage: {
+				Name:   titanium.DefaultImage,
+				Digest: "sha256:" + strings.Repeat("0", 64),
+			},
+		},
+	}
+	server.Server = httptest.NewServer(http.HandlerFunc(server.serveHTTP))
+
+	return server
+}
+
+// Endpoint to pass to titanium.NewHttpClient.
+func (server *Server) Endpoint() string {
+	return server.URL + "/"
+}
+
+// Returns a client talking to this server.
+func (server *Server) Client(token string) *titanium.HttpClient {
+	return titanium.NewHttpClient(server.Endpoint(), token)
+}
+
+// Causes the next count requests to be processed, but have their connection
+// closed before a response is written. Useful to simulate timeouts after the
+// server has accepted a request.
+func (server *Server) DropResponses(count int) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	server.drop = count
+}
+
+// Number of clusters created so far.
+func (server *Server) ClusterCount() int {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	return len(server.clusters)
+}
+
+// Returns a copy of the stored cluster.
+func (server *Server) GetCluster(id int64) (Cluster, bool) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	cluster, ok := server.clusters[id]
+	if !ok {
+		return Cluster{}, false
+	}
+	return *cluster, true
+}
+
+// Stores a project definition as is, bypassing validation.
+func (server *Server) PutProject(definition titanium.ProjectDefinition) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	server.projects[definition.Name] = &definition
+}
+
+// Returns a copy of the stored project definition.
+func (server *Server) GetProject(name string) (titanium.ProjectDefinition, bool) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	definition, ok := server.projects[name]
+	if !ok {
+		return titanium.ProjectDefinition{}, false
+	}
+	return *definition, true
+}
+
+// Stores an image, replacing any of the same name. Only the default image
+// exists initially.
+func (server *Server) PutImage(image titanium.Image) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	server.images[image.Name] = image
+}
+
+// Adds an instance to a cluster and returns its id. The instance is served as
+// is, its Status and Log are left to the caller.
+func (server *Server) AddInstance(clusterId int64, instance titanium.Instance) (int64, bool) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	cluster, ok := server.clusters[clusterId]
+	if !ok {
+		return 0, false
+	}
+
+	if instance.StdoutString == "" {
+		instance.StdoutString = "0"
+	}
+	if instance.StderrString == "" {
+		instance.StderrString = "0"
+	}
+
+	id := server.nextId
+	server.nextId++
+	server.instances[id] = &instance
+	cluster.Instances = append(cluster.Instances, id)
+	return id, true
+}
+
+// Replaces the stored instance, keeping its id.
+func (server *Server) SetInstance(id int64, instance titanium.Instance) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	if stored, ok := server.instances[id]; ok {
+		instance.StdoutString = stored.StdoutString
+		instance.StderrString = stored.StderrString
+		*stored = instance
+	}
+}
+
+func (server *Server) SetClusterStatus(id int64, status int) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	if cluster, ok := server.clusters[id]; ok {
+		cluster.Status = titanium.ClusterStatusStrings[status]
+	}
+}
+
+func (server *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	var output interface{}
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	switch {
+	case path == titanium.ClustersEndpoint && r.Method == "POST":
+		output = server.createCluster(r)
+	case path == titanium.ClustersEndpoint && r.Method == "GET":
+		output = server.listClusters(r.URL.Query().Get("project"))
+	case strings.HasPrefix(path, titanium.ClustersEndpoint) && r.Method == "GET":
+		output = server.getCluster(strings.TrimPrefix(path, titanium.ClustersEndpoint))
+	case strings.HasPrefix(path, titanium.InstancesEndpoint) && r.Method == "GET":
+		output = server.getInstance(strings.TrimPrefix(path, titanium.InstancesEndpoint))
+	case path == titanium.ProjectsEndpoint && r.Method == "POST":
+		output = server.createProject(r)
+	case strings.HasPrefix(path, titanium.ProjectsEndpoint) && r.Method == "GET":
+		output = server.getProject(strings.TrimPrefix(path, titanium.ProjectsEndpoint))
+	case strings.HasPrefix(path, titanium.ProjectsEndpoint) && r.Method == "PATCH":
+		output = server.updateProject(strings.TrimPrefix(path, titanium.ProjectsEndpoint), r)
+	case path == titanium.ImagesEndpoint && r.Method == "GET":
+		output = server.listImages()
+	case strings.HasPrefix(path, titanium.ImagesEndpoint) && r.Method == "GET":
+		output = server.getImage(strings.TrimPrefix(path, titanium.ImagesEndpoint))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		output = failure(fmt.Sprintf("Unknown endpoint %s %s", r.Method, path))
+	}
+
+	if server.drop > 0 {
+		server.drop--
+		dropConnection(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(output)
+}
+
+func (server *Server) createCluster(r *http.Request) interface{} {
+	var request titanium.CreateClusterRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		return failure(err.Error())
+	}
+
+	// Retried request, hand back the original cluster
+	if request.IdempotencyKey != "" {
+		if id, ok := server.keys[request.IdempotencyKey]; ok {
+			return titanium.CreateClusterResponse{
+				ClusterId: strconv.FormatInt(id, 10),
+			}
+		}
+	}
+
+	if _, ok := titanium.TypeStringsTo[request.Type]; !ok || request.Type == titanium.TypeStrings[titanium.InvalidClusterType] {
+		return failure("Invalid cluster type: " + request.Type)
+	}
+
+	id := server.nextId
+	server.nextId++
+	server.clusters[id] = &Cluster{
+		Id:      id,
+		Request: request,
+		Status:  titanium.ClusterStatusStrings[titanium.ClusterWaitingStatus],
+	}
+	if request.IdempotencyKey != "" {
+		server.keys[request.IdempotencyKey] = id
+	}
+
+	return titanium.CreateClusterResponse{
+		ClusterId: strconv.FormatInt(id, 10),
+	}
+}
+
+func (server *Server) getCluster(idString string) interface{} {
+	id, err := strconv.ParseInt(idString, 10, 64)
+	if err != nil {
+		return failure(err.Error())
+	}
+
+	cluster, ok := server.clusters[id]
+	if !ok {
+		return failure("Cluster not found")
+	}
+
+	return cluster.response()
+}
+
+func (server *Server) listClusters(project string) interface{} {
+	ids := make([]int64, 0, len(server.clusters))
+	for id, cluster := range server.clusters {
+		if project == "" || cluster.Request.Project == project {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	output := listClustersResponse{
+		Clusters: make([]clusterResponse, len(ids)),
+	}
+	for index, id := range ids {
+		output.Clusters[index] = server.clusters[id].response()
+	}
+	return output
+}
+
+func (server *Server) getInstance(idString string) interface{} {
+	id, err := strconv.ParseInt(idString, 10, 64)
+	if err != nil {
+		return failure(err.Error())
+	}
+
+	instance, ok := server.instances[id]
+	if !ok {
+		return failure("Instance not found")
+	}
+	return instance
+}
+
+func (server *Server) createProject(r *http.Request) interface{} {
+	var request titanium.CreateProjectRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		return failure(err.Error())
+	}
+
+	if _, ok := server.projects[request.Name]; ok {
+		return failure("Project already exists")
+	}
+
+	server.projects[request.Name] = &titanium.ProjectDefinition{
+		Name:   request.Name,
+		Public: request.Public,
+		Type:   titanium.ProjectTypeToString[titanium.ProjectKernelType],
+	}
+	return titanium.Response{}
+}
+
+func (server *Server) getProject(name string) interface{} {
+	definition, ok := server.projects[name]
+	if !ok {
+		return failure("Project not found")
+	}
+
+	return titanium.GetProjectResponse{
+		Project: *definition,
+	}
+}
+
+func (server *Server) updateProject(name string, r *http.Request) interface{} {
+	definition, ok := server.projects[name]
+	if !ok {
+		return failure("Project not found")
+	}
+
+	var request updateProjectRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		return failure(err.Error())
+	}
+
+	// Fields left nil are not updated
+	if request.Title != nil {
+		definition.Title = *request.Title
+	}
+	if request.Description != nil {
+		definition.Description = *request.Description
+	}
+	if request.Public != nil {
+		definition.Public = *request.Public
+	}
+	switch request.Type {
+	case titanium.ProjectTypeToString[titanium.ProjectSystemType]:
+		definition.Type = request.Type
+		definition.Interfaces = request.Interfaces
+		definition.Configuration = request.Configuration
+		definition.Kernel = nil
+	case titanium.ProjectTypeToString[titanium.ProjectKernelType]:
+		definition.Type = request.Type
+		definition.Interfaces = nil
+		definition.Configuration = nil
+		definition.Kernel = request.Kernel
+	}
+
+	return titanium.Response{}
+}
+
+// Body of the project PATCH requests the client sends.
+type updateProjectRequest struct {
+	Title         *string                        `json:"title"`
+	Description   *string                        `json:"description"`
+	Public        *bool                          `json:"public"`
+	Interfaces    []titanium.ProjectInterface    `json:"interfaces"`
+	Configuration []titanium.ConfigurationEntity `json:"configuration"`
+	Kernel        *titanium.Kernel               `json:"kernel"`
+	Type          string                         `json:"type"`
+}
+
+type clusterResponse struct {
+	titanium.Response
+	ClusterId string   `json:"cluster_id"`
+	Name      string   `json:"name"`
+	Project   string   `json:"project"`
+	Status    string   `json:"status"`
+	Clusters  []string `json:"clusters"`
+	Instances []string `json:"instances"`
+}
+
+type listClustersResponse struct {
+	titanium.Response
+	Clusters []clusterResponse `json:"clusters"`
+}
+
+func (cluster *Cluster) response() clusterResponse {
+	return clusterResponse{
+		ClusterId: strconv.FormatInt(cluster.Id, 10),
+		Name:      cluster.Request.Name,
+		Project:   cluster.Request.Project,
+		Status:    cluster.Status,
+		Clusters:  formatIds(cluster.Clusters),
+		Instances: formatIds(cluster.Instances),
+	}
+}
+
+func (server *Server) listImages() interface{} {
+	names := make([]string, 0, len(server.images))
+	for name := range server.images {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	output := titanium.ListImagesResponse{
+		Images: make([]titanium.Image, len(names)),
+	}
+	for index, name := range names {
+		output.Images[index] = server.images[name]
+	}
+	return output
+}
+
+// Serves 'name' and 'name/digests/digest' paths.
+func (server *Server) getImage(path string) interface{} {
+	name, digest := path, ""
+	if index := strings.Index(path, "/digests/"); index >= 0 {
+		name, digest = path[:index], path[index+len("/digests/"):]
+	}
+
+	image, ok := server.images[name]
+	if !ok || (digest != "" && image.Digest != digest) {
+		return failure("Image not found")
+	}
+	return titanium.GetImageResponse{
+		Image: image,
+	}
+}
+
+func failure(description string) titanium.Response {
+	return titanium.Response{
+		Code:        failureCode,
+		Description: description,
+	}
+}
+
+func formatIds(ids []int64) []string {
+	output := make([]string, len(ids))
+	for index, id := range ids {
+		output[index] = strconv.FormatInt(id, 10)
+	}
+	return output
+}
+
+// Closes the connection without writing a response. Writers that can not be
+// hijacked, such as HTTP/2 ones, are answered with 503 Service Unavailable
+// instead, which the client sees as a failed request all the same.
+func dropConnection(w http.ResponseWriter) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "titaniumtest: dropped response", http.StatusServiceUnavailable)
+		return
+	}
+
+	conn, _, err := hijacker.Hijack()
+	if err != nil {
+		http.Error(w, "titaniumtest: dropped response", http.StatusServiceUnavailable)
+		return
+	}
+	conn.Close()
+}
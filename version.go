@@ -0,0 +1,277 @@
+package titanium
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/atomosio/common"
+)
+
+var (
+	ErrProjectVersionImmutable = errors.New("Published project versions can not be modified")
+)
+
+// Separates the project name from its version or tag in a project reference.
+const ProjectRefSeparator = "@"
+
+// An immutable snapshot of a project definition.
+type ProjectVersion struct {
+	Project string `json:"project"`
+	Version string `json:"version"`
+	Message string `json:"message,omitempty"`
+	// Unix timestamp
+	Created int64    `json:"created"`
+	Tags    []string `json:"tags,omitempty"`
+}
+
+type PublishProjectVersionRequest struct {
+	Message string `json:"message,omitempty"`
+}
+
+type ProjectVersionResponse struct {
+	Response
+	ProjectVersion
+}
+
+type ListProjectVersionsResponse struct {
+	Response
+	Versions []ProjectVersion `json:"versions"`
+}
+
+type TagProjectVersionRequest struct {
+	Version string `json:"version"`
+}
+
+// Splits a project reference of the form 'name' or 'name@version' into its
+// parts. The version may also be a tag.
+func ParseProjectRef(ref string) (name, version string, err error) {
+	name = ref
+	if index := strings.Index(ref, ProjectRefSeparator); index >= 0 {
+		name, version = ref[:index], ref[index+1:]
+		if version == "" || strings.Contains(version, ProjectRefSeparator) {
+			return "", "", fmt.Errorf("Invalid project reference %q", ref)
+		}
+	}
+
+	if name == "" {
+		return "", "", fmt.Errorf("Invalid project reference %q", ref)
+	}
+	return name, version, nil
+}
+
+// Builds a reference pinning project to version.
+func ProjectRef(project, version string) string {
+	if version == "" {
+		return project
+	}
+	return project + ProjectRefSeparator + version
+}
+
+// Snapshots the current definition of a project as a new version.
+func (client *HttpClient) PublishProjectVersion(ctx context.Context, project, message string) (ProjectVersion, error) {
+	request := PublishProjectVersionRequest{
+		Message: message,
+	}
+
+	// Post and unmarshal response
+	var response ProjectVersionResponse
+	addr := fmt.Sprintf("%s/%s/versions/", ProjectsEndpoint, project)
+	err := client.DoMethodAndUnmarshalContext(ctx, "POST", addr, &request, &response)
+	if err != nil {
+		return ProjectVersion{}, err
+	}
+
+	if response.Code != common.Success {
+		return ProjectVersion{}, errors.New(response.Description)
+	}
+
+	return response.ProjectVersion, nil
+}
+
+// Lists the published versions of a project, oldest first.
+func (client *HttpClient) ListProjectVersions(ctx context.Context, project string) ([]ProjectVersion, error) {
+	var response ListProjectVersionsResponse
+
+	// Get and unmarshal
+	addr := fmt.Sprintf("%s/%s/versions/", ProjectsEndpoint, project)
+	err := client.DoEmptyMethodAndUnmarshalContext(ctx, "GET", addr, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.Code != common.Success {
+		return nil, errors.New(response.Description)
+	}
+
+	sort.SliceStable(response.Versions, func(i, j int) bool {
+		return response.Versions[i].Created < response.Versions[j].Created
+	})
+	return response.Versions, nil
+}
+
+// Points tag at a published version of a project, moving it if it already
+// exists.
+func (client *HttpClient) TagProjectVersion(ctx context.Context, project, version, tag string) error {
+	if tag == "" || strings.Contains(tag, ProjectRefSeparator) {
+		return fmt.Errorf("Invalid tag %q", tag)
+	}
+
+	request := TagProjectVersionRequest{
+		Version: version,
+	}
+
+	//send request
+	response := Response{}
+	addr := fmt.Sprintf("%s/%s/tags/%s", ProjectsEndpoint, project, tag)
+	err := client.DoMethodAndUnmarshalContext(ctx, "PUT", addr, &request, &response)
+	if err != nil {
+		return err
+	}
+
+	if response.Code != common.Success {
+		return errors.New(response.Description)
+	}
+
+	return nil
+}
+
+// Returns the version a project reference currently stands for. Unpinned
+// references resolve to the latest published version, tags to the version
+// they point at.
+func (client *HttpClient) ResolveProjectVersion(ctx context.Context, ref string) (string, error) {
+	name, version, err := ParseProjectRef(ref)
+	if err != nil {
+		return "", err
+	}
+	if version == "" {
+		version = "latest"
+	}
+
+	var response ProjectVersionResponse
+
+	// Get and unmarshal
+	addr := fmt.Sprintf("%s/%s/versions/%s/info", ProjectsEndpoint, name, version)
+	err = client.DoEmptyMethodAndUnmarshalContext(ctx, "GET", addr, &response)
+	if err != nil {
+		return "", err
+	}
+
+	if response.Code != common.Success {
+		return "", errors.New(response.Description)
+	}
+
+	return response.Version, nil
+}
+
+// Records the exact versions of every project a system references, directly
+// or through nested systems, so later runs use the same code.
+type Lockfile struct {
+	System string `json:"system"`
+	// Project references as written in definitions, mapped to pinned
+	// references.
+	Projects map[string]string `json:"projects"`
+}
+
+// Resolves the versions of the system and everything it references.
+func (client *HttpClient) LockSystem(ctx context.Context, system string) (*Lockfile, error) {
+	lock := &Lockfile{
+		System:   system,
+		Projects: make(map[string]string),
+	}
+
+	var visit func(ref string) error
+	visit = func(ref string) error {
+		if _, ok := lock.Projects[ref]; ok {
+			return nil
+		}
+
+		name, _, err := ParseProjectRef(ref)
+		if err != nil {
+			return err
+		}
+		version, err := client.ResolveProjectVersion(ctx, ref)
+		if err != nil {
+			return fmt.Errorf("Failed to resolve %q: %s", ref, err)
+		}
+		pinned := ProjectRef(name, version)
+		lock.Projects[ref] = pinned
+
+		definition, err := client.GetProjectDefinition(ctx, pinned)
+		if err != nil {
+			return err
+		}
+		for _, entity := range definition.Configuration {
+			if err := visit(entity.Kernel); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	err := visit(system)
+	if err != nil {
+		return nil, err
+	}
+	return lock, nil
+}
+
+// Returns the pinned reference for ref, or ref itself if the lockfile does not
+// know it.
+func (lock *Lockfile) Pin(ref string) string {
+	if pinned, ok := lock.Projects[ref]; ok {
+		return pinned
+	}
+	return ref
+}
+
+// Returns a copy of the definition with every entity kernel pinned.
+func (lock *Lockfile) PinDefinition(definition ProjectDefinition) ProjectDefinition {
+	entities := make([]ConfigurationEntity, len(definition.Configuration))
+	for index, entity := range definition.Configuration {
+		entity.Kernel = lock.Pin(entity.Kernel)
+		entities[index] = entity
+	}
+	definition.Configuration = entities
+	return definition
+}
+
+// Returns a lookup that serves pinned definitions, for use with NewResolver.
+func (lock *Lockfile) ProjectLookup(lookup ProjectLookup) ProjectLookup {
+	return func(project string) (ProjectDefinition, error) {
+		definition, err := lookup(lock.Pin(project))
+		if err != nil {
+			return definition, err
+		}
+		return lock.PinDefinition(definition), nil
+	}
+}
+
+func LoadLockfile(path string) (*Lockfile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	lock := &Lockfile{}
+	err = json.Unmarshal(data, lock)
+	if err != nil {
+		return nil, err
+	}
+	if lock.Projects == nil {
+		lock.Projects = make(map[string]string)
+	}
+	return lock, nil
+}
+
+func (lock *Lockfile) Save(path string) error {
+	data, err := json.MarshalIndent(lock, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,488 @@
+package titanium
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	ErrWorkflowWaitForFinishTimeout = errors.New("Workflow did not finish within timeout period")
+	ErrWorkflowMismatch             = errors.New("Workflow state file belongs to a different workflow")
+	ErrWorkflowFailed               = errors.New("Workflow steps failed")
+)
+
+// A set of clusters with dependencies between them. Clusters only start once
+// every cluster they depend on has stopped.
+type Workflow struct {
+	Name  string
+	Steps []*WorkflowStep
+}
+
+type WorkflowStep struct {
+	// Unique name of the step within the workflow
+	Name string
+	Spec ClusterSpec
+
+	// Names of the steps that must finish before this one starts
+	DependsOn []string
+	// Interfaces of this step bound to the interfaces of upstream steps
+	Inputs map[string]WorkflowOutput
+}
+
+// Refers to an interface of another step in the workflow.
+type WorkflowOutput struct {
+	Step      string
+	Interface string
+}
+
+// Persisted progress of a workflow. Steps are keyed by name, and only hold
+// the steps submitted so far.
+type WorkflowState struct {
+	Workflow string `json:"workflow"`
+	// Names of every step of the workflow, in submission order
+	Order []string                      `json:"order"`
+	Steps map[string]*WorkflowStepState `json:"steps"`
+}
+
+type WorkflowStepState struct {
+	IdempotencyKey string            `json:"idempotency_key"`
+	ClusterId      int64             `json:"cluster_id,omitempty"`
+	Interfaces     map[string]string `json:"interfaces,omitempty"`
+	// Values of the interfaces the project of the step writes to, which
+	// downstream steps may bind to
+	Outputs map[string]string `json:"outputs,omitempty"`
+	Status  string            `json:"status,omitempty"`
+	// Whether the cluster stopped with instances that failed for good
+	Failed bool `json:"failed,omitempty"`
+}
+
+func NewWorkflow(name string) *Workflow {
+	return &Workflow{
+		Name: name,
+	}
+}
+
+// Adds a step creating a cluster from spec once the steps named in dependsOn
+// have finished.
+func (workflow *Workflow) Add(name string, spec ClusterSpec, dependsOn ...string) *WorkflowStep {
+	step := &WorkflowStep{
+		Name:      name,
+		Spec:      spec,
+		DependsOn: dependsOn,
+		Inputs:    make(map[string]WorkflowOutput),
+	}
+	workflow.Steps = append(workflow.Steps, step)
+	return step
+}
+
+// Binds the interface of this step to the interface of an upstream step. The
+// upstream step is added to the dependencies if needed.
+func (step *WorkflowStep) Bind(iface, upstream, upstreamIface string) *WorkflowStep {
+	step.Inputs[iface] = WorkflowOutput{
+		Step:      upstream,
+		Interface: upstreamIface,
+	}
+
+	for _, name := range step.DependsOn {
+		if name == upstream {
+			return step
+		}
+	}
+	step.DependsOn = append(step.DependsOn, upstream)
+	return step
+}
+
+func (workflow *Workflow) step(name string) *WorkflowStep {
+	for _, step := range workflow.Steps {
+		if step.Name == name {
+			return step
+		}
+	}
+	return nil
+}
+
+// Checks the workflow for unknown or cyclic dependencies and returns the
+// steps in the order they must be submitted.
+func (workflow *Workflow) Sorted() ([]*WorkflowStep, error) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	marks := make(map[string]int)
+	for _, step := range workflow.Steps {
+		if step.Name == "" {
+			return nil, errors.New("Workflow step without a name")
+		}
+		if _, ok := marks[step.Name]; ok {
+			return nil, fmt.Errorf("Duplicate workflow step %q", step.Name)
+		}
+		marks[step.Name] = unvisited
+	}
+
+	output := make([]*WorkflowStep, 0, len(workflow.Steps))
+	var visit func(step *WorkflowStep) error
+	visit = func(step *WorkflowStep) error {
+		switch marks[step.Name] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("Workflow step %q depends on itself", step.Name)
+		}
+		marks[step.Name] = visiting
+
+		for _, name := range step.DependsOn {
+			upstream := workflow.step(name)
+			if upstream == nil {
+				return fmt.Errorf("Workflow step %q depends on unknown step %q", step.Name, name)
+			}
+			if err := visit(upstream); err != nil {
+				return err
+			}
+		}
+		for iface, input := range step.Inputs {
+			if !step.dependsOn(input.Step) {
+				return fmt.Errorf("Workflow step %q binds %q to %q without depending on it", step.Name, iface, input.Step)
+			}
+		}
+
+		marks[step.Name] = visited
+		output = append(output, step)
+		return nil
+	}
+
+	for _, step := range workflow.Steps {
+		if err := visit(step); err != nil {
+			return nil, err
+		}
+	}
+
+	return output, nil
+}
+
+func (step *WorkflowStep) dependsOn(name string) bool {
+	for _, dependency := range step.DependsOn {
+		if dependency == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Reads the workflow state stored at path. A missing file results in an
+// empty state.
+func LoadWorkflowState(path string) (*WorkflowState, error) {
+	state := &WorkflowState{
+		Steps: make(map[string]*WorkflowStepState),
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, state)
+	if err != nil {
+		return nil, err
+	}
+	if state.Steps == nil {
+		state.Steps = make(map[string]*WorkflowStepState)
+	}
+
+	return state, nil
+}
+
+// Writes the state to path. The file is replaced atomically so a crash never
+// leaves a partially written state behind.
+func (state *WorkflowState) Save(path string) error {
+	data, err := json.MarshalIndent(state, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Returns whether every step of the workflow has been submitted and has
+// stopped, whether it succeeded or not. See Failed.
+func (state *WorkflowState) IsStopped() bool {
+	if len(state.Order) == 0 {
+		return false
+	}
+
+	for _, name := range state.Order {
+		step, ok := state.Steps[name]
+		if !ok || step.ClusterId == 0 || step.Status != ClusterStatusStrings[ClusterStoppedStatus] {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the names of the steps whose cluster stopped with failed instances.
+func (state *WorkflowState) Failed() []string {
+	var output []string
+	for name, step := range state.Steps {
+		if step.Failed {
+			output = append(output, name)
+		}
+	}
+	sort.Strings(output)
+	return output
+}
+
+// Submits every step of the workflow that has not been submitted yet,
+// recording progress in the state file at path. Calling it again with the same
+// path after a crash or restart resumes where the previous call stopped,
+// without creating any cluster twice.
+func (client *HttpClient) SubmitWorkflow(ctx context.Context, workflow *Workflow, path string) (*WorkflowState, error) {
+	steps, err := workflow.Sorted()
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := LoadWorkflowState(path)
+	if err != nil {
+		return nil, err
+	}
+	if state.Workflow == "" {
+		state.Workflow = workflow.Name
+	} else if state.Workflow != workflow.Name {
+		return nil, ErrWorkflowMismatch
+	}
+
+	// Record every step up front, so that a run stopped halfway is not taken
+	// for finished
+	state.Order = make([]string, len(steps))
+	for index, step := range steps {
+		state.Order[index] = step.Name
+	}
+	err = state.Save(path)
+	if err != nil {
+		return state, err
+	}
+
+	for _, step := range steps {
+		stepState, ok := state.Steps[step.Name]
+		if !ok {
+			stepState = &WorkflowStepState{}
+			state.Steps[step.Name] = stepState
+		}
+		if stepState.ClusterId != 0 {
+			continue
+		}
+
+		// Persist the key before submitting, so a resumed run reuses it
+		if stepState.IdempotencyKey == "" {
+			stepState.IdempotencyKey, err = NewIdempotencyKey()
+			if err != nil {
+				return state, err
+			}
+			err = state.Save(path)
+			if err != nil {
+				return state, err
+			}
+		}
+
+		spec := step.Spec
+		spec.IdempotencyKey = stepState.IdempotencyKey
+		spec.Interfaces = make(map[string]string)
+		for iface, value := range step.Spec.Interfaces {
+			spec.Interfaces[iface] = value
+		}
+		spec.DependsOn = append([]int64(nil), step.Spec.DependsOn...)
+		for _, name := range step.DependsOn {
+			spec.DependsOn = append(spec.DependsOn, state.Steps[name].ClusterId)
+		}
+		for iface, input := range step.Inputs {
+			upstream := state.Steps[input.Step]
+			if upstream.Outputs == nil {
+				// Submitted by a version that did not record outputs
+				outputs, err := client.outputInterfaces(ctx, workflow.step(input.Step).Spec.Project)
+				if err != nil {
+					return state, err
+				}
+				upstream.Outputs = filterInterfaces(upstream.Interfaces, outputs)
+			}
+
+			value, ok := upstream.Outputs[input.Interface]
+			if !ok {
+				return state, fmt.Errorf("Workflow step %q has no output %q bound", input.Step, input.Interface)
+			}
+			spec.Interfaces[iface] = value
+		}
+
+		outputs, err := client.outputInterfaces(ctx, spec.Project)
+		if err != nil {
+			return state, err
+		}
+
+		cluster, err := client.CreateCluster(ctx, spec)
+		if err != nil {
+			return state, err
+		}
+
+		stepState.ClusterId = cluster.Id
+		stepState.Interfaces = spec.Interfaces
+		stepState.Outputs = filterInterfaces(spec.Interfaces, outputs)
+		stepState.Status = cluster.Status
+		err = state.Save(path)
+		if err != nil {
+			return state, err
+		}
+	}
+
+	return state, nil
+}
+
+// Returns the names of the interfaces the project writes to.
+func (client *HttpClient) outputInterfaces(ctx context.Context, project string) (map[string]bool, error) {
+	definition, err := client.GetProjectDefinition(ctx, project)
+	if err != nil {
+		return nil, err
+	}
+
+	output := make(map[string]bool)
+	for _, pinterface := range definition.Interfaces {
+		if pinterface.Direction == OutDirection || pinterface.Direction == InOutDirection {
+			output[pinterface.Name] = true
+		}
+	}
+	if definition.Kernel != nil {
+		for _, kinterface := range definition.Kernel.Interfaces {
+			if kinterface.Direction == OutDirection || kinterface.Direction == InOutDirection {
+				output[kinterface.Name] = true
+			}
+		}
+	}
+	return output, nil
+}
+
+func filterInterfaces(interfaces map[string]string, names map[string]bool) map[string]string {
+	output := make(map[string]string)
+	for iface, value := range interfaces {
+		if names[iface] {
+			output[iface] = value
+		}
+	}
+	return output
+}
+
+// Refreshes the status of every submitted step in the state file at path. A
+// stopped step is marked failed if any of its instances failed and was not
+// retried successfully.
+func (client *HttpClient) UpdateWorkflowState(ctx context.Context, path string) (*WorkflowState, error) {
+	state, err := LoadWorkflowState(path)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(state.Steps))
+	for name := range state.Steps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		stepState := state.Steps[name]
+		if stepState.ClusterId == 0 {
+			continue
+		}
+
+		cluster, err := client.GetClusterContext(ctx, stepState.ClusterId)
+		if err != nil {
+			return state, err
+		}
+		stepState.Status = cluster.Status
+
+		if cluster.IsStopped() {
+			stepState.Failed, err = client.clusterFailed(ctx, cluster)
+			if err != nil {
+				return state, err
+			}
+		}
+	}
+
+	return state, state.Save(path)
+}
+
+// Returns whether the latest attempt of any instance of the cluster failed.
+func (client *HttpClient) clusterFailed(ctx context.Context, cluster Cluster) (bool, error) {
+	instances := make(map[int64]Instance)
+	for _, id := range cluster.Instances {
+		instance, err := client.GetInstanceContext(ctx, id)
+		if err != nil {
+			return false, err
+		}
+		instances[id] = instance
+	}
+
+	retried := retriedInstances(instances)
+	for id, instance := range instances {
+		if instance.IsFailed() && !retried[id] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Waits for every step of the workflow to stop. ErrWorkflowFailed is returned
+// if any step failed, Failed on the state names them.
+func (client *HttpClient) WaitForWorkflowToFinish(ctx context.Context, path string, timeout time.Duration) error {
+	waitTill := time.Now().Add(timeout)
+
+	for {
+		state, err := client.UpdateWorkflowState(ctx, path)
+		if err != nil {
+			return err
+		}
+
+		// If we're done, exit function
+		if state.IsStopped() {
+			if failed := state.Failed(); len(failed) > 0 {
+				return fmt.Errorf("%w: %s", ErrWorkflowFailed, strings.Join(failed, ", "))
+			}
+			return nil
+		}
+
+		if time.Now().After(waitTill) {
+			return ErrWorkflowWaitForFinishTimeout
+		}
+
+		// Go to sleep for a bit
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(SpinSleepDuration):
+		}
+	}
+}